@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// archiveTimeout bounds the whole download; the audio is streamed, so this
+// is about slow clients rather than memory.
+const archiveTimeout = 30 * time.Minute
+
+// archiveVoice is a voice message as listed in voice-messages.json, with
+// the path of its clip inside the archive.
+type archiveVoice struct {
+	voiceMessageMetadata
+	File string `json:"file"`
+}
+
+// handleArchive streams GET /admin/archive.zip: every text message as
+// messages.json, messages.csv and a readable index.html, plus every voice
+// clip under voice/ named by guest and time. Clips are copied from the
+// database one row at a time, so the archive is never held in memory.
+func (s *server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), archiveTimeout)
+	defer cancel()
+	extendWriteDeadline(w, r, archiveTimeout)
+
+	tx, messages, voices, err := openArchive(ctx, s.pool)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query archive", "err", err)
+		serverError(w, r, "failed to build archive")
+		return
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="guestbook-%s.zip"`, time.Now().Format("20060102")))
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	if err := writeArchive(ctx, tx, zw, messages, voices); err != nil {
+		// The status is already sent; the client sees a truncated archive.
+		slog.ErrorContext(r.Context(), "write archive", "err", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "finish archive", "err", err)
+	}
+}
+
+// openArchive lists every message and voice note in a read-only snapshot
+// and returns it open, so the clips written afterwards match the listing.
+// The caller rolls tx back.
+func openArchive(ctx context.Context, pool *pgxpool.Pool) (pgx.Tx, []message, []archiveVoice, error) {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	messages, err := collectRows(ctx, tx, `SELECT `+messageColumns+` FROM messages ORDER BY created_at, id`, scanMessage)
+	if err != nil {
+		tx.Rollback(context.WithoutCancel(ctx))
+		return nil, nil, nil, err
+	}
+	voices, err := collectRows(ctx, tx, `SELECT `+voiceMessageColumns+` FROM voice_messages ORDER BY created_at, id`, func(row pgx.Row) (archiveVoice, error) {
+		vm, err := scanVoiceMessage(row)
+		return archiveVoice{voiceMessageMetadata: vm, File: voiceArchiveName(vm)}, err
+	})
+	if err != nil {
+		tx.Rollback(context.WithoutCancel(ctx))
+		return nil, nil, nil, err
+	}
+	return tx, messages, voices, nil
+}
+
+func writeArchive(ctx context.Context, tx pgx.Tx, zw *zip.Writer, messages []message, voices []archiveVoice) error {
+	now := time.Now()
+	if err := writeZipFile(zw, "messages.json", now, zip.Deflate, func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(messages)
+	}); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "messages.csv", now, zip.Deflate, func(f io.Writer) error {
+		return writeMessagesCSV(f, messages)
+	}); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "voice-messages.json", now, zip.Deflate, func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(voices)
+	}); err != nil {
+		return err
+	}
+	if err := writeZipFile(zw, "index.html", now, zip.Deflate, func(f io.Writer) error {
+		return archiveIndexTemplate.Execute(f, map[string]any{"Messages": messages, "Voices": voices, "Generated": now})
+	}); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, audio FROM voice_messages ORDER BY created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for i := 0; rows.Next(); i++ {
+		var id int
+		var audio []byte
+		if err := rows.Scan(&id, &audio); err != nil {
+			return err
+		}
+		if i >= len(voices) || voices[i].ID != id {
+			return fmt.Errorf("voice message %d not in listing", id)
+		}
+		// Compressed audio does not shrink further; store it as is.
+		if err := writeZipFile(zw, voices[i].File, voices[i].CreatedAt, zip.Store, func(f io.Writer) error {
+			_, err := f.Write(audio)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func writeZipFile(zw *zip.Writer, name string, modified time.Time, method uint16, write func(io.Writer) error) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+	if err != nil {
+		return err
+	}
+	return write(f)
+}
+
+func writeMessagesCSV(w io.Writer, messages []message) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "entry_id", "guest_name", "text", "approved", "invite_id", "table_label", "created_at"})
+	for _, m := range messages {
+		cw.Write([]string{
+			strconv.Itoa(m.ID), strconv.Itoa(m.EntryID), csvText(m.GuestName), csvText(m.Text), strconv.FormatBool(m.Approved),
+			m.InviteID, csvText(m.TableLabel), m.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText makes text a guest typed safe to open in a spreadsheet: a cell
+// starting with =, +, -, @, a tab or a carriage return would be read as a
+// formula, so it gets a leading apostrophe, which spreadsheets hide.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// voiceArchiveName is voice/<time>_<guest>_<id>.<ext>. The id keeps names
+// unique when one guest records twice in a second.
+func voiceArchiveName(vm voiceMessageMetadata) string {
+	return fmt.Sprintf("voice/%s_%s_%d%s", vm.CreatedAt.UTC().Format("2006-01-02_150405"), fileSafeName(vm.GuestName), vm.ID, audioExtension(vm.MimeType))
+}
+
+// fileSafeName keeps letters and digits in any script and turns everything
+// else into single dashes.
+func fileSafeName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	if safe := strings.TrimSuffix(b.String(), "-"); safe != "" {
+		return safe
+	}
+	return "guest"
+}
+
+func audioExtension(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	switch strings.TrimSpace(strings.ToLower(base)) {
+	case "audio/webm", "video/webm":
+		return ".webm"
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mp4", "audio/x-m4a", "audio/aac":
+		return ".m4a"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return ".wav"
+	}
+	if exts, err := mime.ExtensionsByType(base); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// collectRows runs query and scans every row with scan.
+func collectRows[T any](ctx context.Context, tx pgx.Tx, query string, scan func(pgx.Row) (T, error)) ([]T, error) {
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+var archiveIndexTemplate = template.Must(template.New("archive").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Guestbook</title>
+<style>
+  body { font-family: Georgia, serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+  h1 { font-weight: normal; }
+  article { border-bottom: 1px solid #ddd; padding: 1rem 0; }
+  .who { font-weight: bold; }
+  .when { color: #777; font-size: 0.85rem; }
+  p { white-space: pre-wrap; }
+  audio { width: 100%; }
+</style>
+</head>
+<body>
+<h1>Guestbook</h1>
+<p class="when">Exported {{.Generated.Format "2 January 2006 15:04"}} · {{len .Messages}} messages · {{len .Voices}} voice notes</p>
+<h2>Messages</h2>
+{{range .Messages}}<article>
+  <div class="who">{{if .GuestName}}{{.GuestName}}{{else}}Anonymous{{end}}{{if .TableLabel}} · {{.TableLabel}}{{end}}</div>
+  <div class="when">{{.CreatedAt.Format "2 Jan 2006 15:04"}}</div>
+  <p>{{.Text}}</p>
+</article>
+{{else}}<p>No messages.</p>
+{{end}}
+<h2>Voice notes</h2>
+{{range .Voices}}<article>
+  <div class="who">{{if .GuestName}}{{.GuestName}}{{else}}Anonymous{{end}}{{if .TableLabel}} · {{.TableLabel}}{{end}}</div>
+  <div class="when">{{.CreatedAt.Format "2 Jan 2006 15:04"}} · {{.DurationSeconds}} s</div>
+  {{if .Note}}<p>{{.Note}}</p>{{end}}
+  <audio controls preload="none" src="{{.File}}"></audio>
+  {{if .Transcript}}<p><em>{{.Transcript}}</em></p>{{end}}
+</article>
+{{else}}<p>No voice notes.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestCSVText(t *testing.T) {
+	tests := map[string]string{
+		"":                            "",
+		"Anna":                        "Anna",
+		`=HYPERLINK("http://x","hi")`: `'=HYPERLINK("http://x","hi")`,
+		"+1 from us":                  "'+1 from us",
+		"-- Ben":                      "'-- Ben",
+		"@everyone":                   "'@everyone",
+		"\t=1+1":                      "'\t=1+1",
+		"\r=1+1":                      "'\r=1+1",
+		"Love you both = forever":     "Love you both = forever",
+		"'quoted'":                    "'quoted'",
+	}
+	for in, want := range tests {
+		if got := csvText(in); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteMessagesCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeMessagesCSV(&buf, []message{{ID: 1, GuestName: "=cmd|' /C calc'!A0", Text: "-2+3", CreatedAt: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := records[1][2]; got != "'=cmd|' /C calc'!A0" {
+		t.Errorf("guest_name = %q", got)
+	}
+	if got := records[1][3]; got != "'-2+3" {
+		t.Errorf("text = %q", got)
+	}
+}
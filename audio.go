@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/pion/opus"
+)
+
+var (
+	errAudioUnsupported = errors.New("unsupported audio format")
+	errAudioEmpty       = errors.New("no audio could be decoded")
+	errWAVInvalid       = errors.New("not a valid WAV file")
+	errOggInvalid       = errors.New("not a valid Ogg file")
+	errOpusHead         = errors.New("missing or unsupported Opus header")
+)
+
+// pcmSink receives decoded audio in order as float samples between -1 and
+// 1, interleaved by channel. The slice is reused between calls.
+type pcmSink func(samples []float32, channels, sampleRate int)
+
+// decodeAudio decodes a voice message to PCM. WebM and Ogg with Opus (what
+// browsers record) and PCM WAV are understood; the container is recognised
+// by its magic bytes. Opus is decoded at opusRate (8, 12, 16, 24 or 48
+// kHz); WAV keeps its own rate.
+func decodeAudio(audio []byte, opusRate int, sink pcmSink) error {
+	switch {
+	case bytes.HasPrefix(audio, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return decodeWebMOpus(audio, opusRate, sink)
+	case bytes.HasPrefix(audio, []byte("OggS")):
+		return decodeOggOpus(audio, opusRate, sink)
+	case len(audio) >= 12 && string(audio[:4]) == "RIFF" && string(audio[8:12]) == "WAVE":
+		return decodeWAV(audio, sink)
+	}
+	return errAudioUnsupported
+}
+
+// opusStream decodes the packets of one Opus stream.
+type opusStream struct {
+	decoder  opus.Decoder
+	channels int
+	rate     int
+	preSkip  int
+	pcm      []float32
+	sink     pcmSink
+	decoded  int
+}
+
+// newOpusStream reads an OpusHead packet (RFC 7845 section 5.1), which is
+// also the CodecPrivate of an Opus track in WebM.
+func newOpusStream(head []byte, rate int, sink pcmSink) (*opusStream, error) {
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, errOpusHead
+	}
+	channels := int(head[9])
+	if channels < 1 || channels > 2 {
+		return nil, errOpusHead
+	}
+	decoder, err := opus.NewDecoderWithOutput(rate, channels)
+	if err != nil {
+		return nil, err
+	}
+	return &opusStream{
+		decoder:  decoder,
+		channels: channels,
+		rate:     rate,
+		// Pre-skip is counted at 48 kHz whatever the output rate.
+		preSkip: int(binary.LittleEndian.Uint16(head[10:])) * rate / 48000,
+		// 120 ms is the longest an Opus packet can be.
+		pcm:  make([]float32, rate*120/1000*channels),
+		sink: sink,
+	}, nil
+}
+
+// decode feeds one packet. A damaged packet is skipped rather than failing
+// the whole clip.
+func (o *opusStream) decode(packet []byte) {
+	n, err := o.decoder.DecodeToFloat32(packet, o.pcm)
+	if err != nil || n <= 0 {
+		return
+	}
+	o.decoded++
+	samples := o.pcm[:n*o.channels]
+	if o.preSkip > 0 {
+		skip := min(o.preSkip, n)
+		samples = samples[skip*o.channels:]
+		o.preSkip -= skip
+	}
+	if len(samples) > 0 {
+		o.sink(samples, o.channels, o.rate)
+	}
+}
+
+func decodeWebMOpus(audio []byte, rate int, sink pcmSink) error {
+	var stream *opusStream
+	var streamErr error
+	var trackNumber uint64
+	_, err := parseWebMFrames(audio, func(t *webmTrack, frame []byte) {
+		if t.kind != 2 || t.codec != "A_OPUS" || streamErr != nil {
+			return
+		}
+		if stream == nil {
+			stream, streamErr = newOpusStream(t.codecPrivate, rate, sink)
+			trackNumber = t.number
+		}
+		if stream != nil && t.number == trackNumber {
+			stream.decode(frame)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if streamErr != nil {
+		return streamErr
+	}
+	if stream == nil || stream.decoded == 0 {
+		return errAudioEmpty
+	}
+	return nil
+}
+
+// decodeOggOpus reads the first logical stream of an Ogg file, which
+// Firefox produces when asked for audio/ogg.
+func decodeOggOpus(audio []byte, rate int, sink pcmSink) error {
+	var stream *opusStream
+	var serial uint32
+	var packet []byte
+	packets := 0
+	for pos := 0; pos < len(audio); {
+		if len(audio)-pos < 27 || string(audio[pos:pos+4]) != "OggS" {
+			return errOggInvalid
+		}
+		segments := int(audio[pos+26])
+		bodyStart := pos + 27 + segments
+		if bodyStart > len(audio) {
+			return errOggInvalid
+		}
+		table := audio[pos+27 : bodyStart]
+		bodyLen := 0
+		for _, l := range table {
+			bodyLen += int(l)
+		}
+		if bodyStart+bodyLen > len(audio) {
+			return errOggInvalid
+		}
+		pageSerial := binary.LittleEndian.Uint32(audio[pos+14:])
+		if pos == 0 {
+			serial = pageSerial
+		}
+		if pageSerial == serial {
+			off := bodyStart
+			for _, l := range table {
+				packet = append(packet, audio[off:off+int(l)]...)
+				off += int(l)
+				if l == 255 {
+					continue
+				}
+				switch packets {
+				case 0:
+					var err error
+					if stream, err = newOpusStream(packet, rate, sink); err != nil {
+						return err
+					}
+				case 1:
+					// OpusTags
+				default:
+					stream.decode(packet)
+				}
+				packets++
+				packet = packet[:0]
+			}
+		}
+		pos = bodyStart + bodyLen
+	}
+	if stream == nil || stream.decoded == 0 {
+		return errAudioEmpty
+	}
+	return nil
+}
+
+// decodeWAV reads integer PCM (8 to 32 bit) and float WAV files.
+func decodeWAV(audio []byte, sink pcmSink) error {
+	var format, channels, bits int
+	var sampleRate int
+	var data []byte
+	for pos := 12; pos+8 <= len(audio); {
+		id := string(audio[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(audio[pos+4:]))
+		body := audio[pos+8:]
+		// Streaming writers leave the size at its maximum; take what is there.
+		if size < 0 || size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return errWAVInvalid
+			}
+			format = int(binary.LittleEndian.Uint16(body))
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = int(binary.LittleEndian.Uint16(body[14:]))
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format in its sub-format GUID.
+			if format == 0xFFFE && len(body) >= 26 {
+				format = int(binary.LittleEndian.Uint16(body[24:]))
+			}
+		case "data":
+			data = body
+		}
+		pos += 8 + size + size%2
+	}
+	if channels < 1 || sampleRate < 1 || data == nil {
+		return errWAVInvalid
+	}
+
+	var sample func(b []byte) float32
+	switch {
+	case format == 1 && bits == 8:
+		sample = func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }
+	case format == 1 && bits == 16:
+		sample = func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == 1 && bits == 24:
+		sample = func(b []byte) float32 {
+			return float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == 1 && bits == 32:
+		sample = func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == 3 && bits == 32:
+		sample = func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+	case format == 3 && bits == 64:
+		sample = func(b []byte) float32 { return float32(math.Float64frombits(binary.LittleEndian.Uint64(b))) }
+	default:
+		return errAudioUnsupported
+	}
+
+	width := bits / 8
+	frame := width * channels
+	if len(data) < frame {
+		return errAudioEmpty
+	}
+	buf := make([]float32, 0, 4096*channels)
+	for off := 0; off+frame <= len(data); off += frame {
+		for c := 0; c < channels; c++ {
+			buf = append(buf, sample(data[off+c*width:]))
+		}
+		if len(buf) == cap(buf) {
+			sink(buf, channels, sampleRate)
+			buf = buf[:0]
+		}
+	}
+	if len(buf) > 0 {
+		sink(buf, channels, sampleRate)
+	}
+	return nil
+}
+
+// decodeMono decodes a whole clip and mixes it down to one channel. It
+// returns the samples and their rate.
+func decodeMono(audio []byte, opusRate int) ([]float32, int, error) {
+	var mono []float32
+	rate := 0
+	err := decodeAudio(audio, opusRate, func(samples []float32, channels, sampleRate int) {
+		rate = sampleRate
+		for i := 0; i+channels <= len(samples); i += channels {
+			var sum float32
+			for _, v := range samples[i : i+channels] {
+				if v == v { // NaN in a float WAV
+					sum += v
+				}
+			}
+			mono = append(mono, sum/float32(channels))
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(mono) == 0 {
+		return nil, 0, errAudioEmpty
+	}
+	return mono, rate, nil
+}
+
+// resampleLinear converts between sample rates by linear interpolation,
+// which is good enough for speech.
+func resampleLinear(in []float32, from, to int) []float32 {
+	out := make([]float32, int(int64(len(in))*int64(to)/int64(from)))
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j+1 >= len(in) {
+			out[i] = in[len(in)-1]
+			continue
+		}
+		frac := float32(pos - float64(j))
+		out[i] = in[j]*(1-frac) + in[j+1]*frac
+	}
+	return out
+}
+
+// encodeWAV writes mono samples as a 16-bit PCM WAV file, clipping
+// anything outside -1..1.
+func encodeWAV(mono []float32, rate int) []byte {
+	dataSize := 2 * len(mono)
+	wav := make([]byte, 44+dataSize)
+	copy(wav, "RIFF")
+	binary.LittleEndian.PutUint32(wav[4:], uint32(36+dataSize))
+	copy(wav[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(wav[16:], 16)           // fmt chunk size
+	binary.LittleEndian.PutUint16(wav[20:], 1)            // PCM
+	binary.LittleEndian.PutUint16(wav[22:], 1)            // mono
+	binary.LittleEndian.PutUint32(wav[24:], uint32(rate)) // sample rate
+	binary.LittleEndian.PutUint32(wav[28:], uint32(rate*2))
+	binary.LittleEndian.PutUint16(wav[32:], 2)  // block align
+	binary.LittleEndian.PutUint16(wav[34:], 16) // bits per sample
+	copy(wav[36:], "data")
+	binary.LittleEndian.PutUint32(wav[40:], uint32(dataSize))
+	for i, v := range mono {
+		binary.LittleEndian.PutUint16(wav[44+2*i:], uint16(int16(max(-1, min(1, v))*32767)))
+	}
+	return wav
+}
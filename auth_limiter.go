@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authFailureThreshold = 5                // failures allowed before the first lockout
+	authBaseLockout      = 30 * time.Second // doubled for every failure past the threshold
+	authMaxLockout       = time.Hour
+	authFailureWindow    = time.Hour // failure counters reset after this much quiet time
+	authMaxTrackedKeys   = 10000
+	authPruneTarget      = authMaxTrackedKeys * 9 / 10 // prune down to this, so a flood does not sort on every failure
+	authMaxUsernameBytes = 128
+)
+
+// authLimiter tracks failed admin logins per client IP and per attempted
+// username, locking either out with exponential backoff once they exceed
+// authFailureThreshold.
+type authLimiter struct {
+	mu     sync.Mutex
+	byIP   map[string]*authFailures
+	byUser map[string]*authFailures
+	now    func() time.Time
+	// evictingRecent is set while the maps are so full that failures
+	// within authFailureWindow are being forgotten.
+	evictingRecent bool
+}
+
+type authFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newAuthLimiter() *authLimiter {
+	return &authLimiter{
+		byIP:   make(map[string]*authFailures),
+		byUser: make(map[string]*authFailures),
+		now:    time.Now,
+	}
+}
+
+// lockedFor reports how long the caller must wait before another attempt is
+// accepted for the given IP or username. Zero means the attempt may proceed.
+func (l *authLimiter) lockedFor(ip, user string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	if f, ok := l.byIP[ip]; ok && f.lockedUntil.After(now) {
+		wait = f.lockedUntil.Sub(now)
+	}
+	if f, ok := l.byUser[limiterUserKey(user)]; ok && user != "" && f.lockedUntil.After(now) {
+		if d := f.lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// recordFailure counts a failed attempt and logs an alert whenever it causes
+// a new lockout.
+func (l *authLimiter) recordFailure(ip, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.pruneLocked(now)
+	if d := l.bump(l.byIP, ip, now); d > 0 {
+		slog.Warn("ALERT: admin auth lockout", "ip", ip, "failures", l.byIP[ip].count, "locked", d)
+	}
+	if user != "" {
+		key := limiterUserKey(user)
+		if d := l.bump(l.byUser, key, now); d > 0 {
+			slog.Warn("ALERT: admin auth lockout", "username", key, "failures", l.byUser[key].count, "locked", d, "last_ip", ip)
+		}
+	}
+}
+
+// recordSuccess clears the counters for a client that authenticated.
+func (l *authLimiter) recordSuccess(ip, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.byIP, ip)
+	delete(l.byUser, limiterUserKey(user))
+}
+
+func (l *authLimiter) bump(m map[string]*authFailures, key string, now time.Time) time.Duration {
+	f, ok := m[key]
+	if !ok || now.Sub(f.lastFailure) > authFailureWindow {
+		f = &authFailures{}
+		m[key] = f
+	}
+	f.count++
+	f.lastFailure = now
+	if f.count < authFailureThreshold {
+		return 0
+	}
+	lockout := authBaseLockout << min(f.count-authFailureThreshold, 16)
+	if lockout > authMaxLockout || lockout <= 0 {
+		lockout = authMaxLockout
+	}
+	f.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// pruneLocked caps the maps so a flood of random usernames or addresses
+// cannot grow them without bound. Once a map is full it evicts the entries
+// that failed longest ago, which are the stale ones when there are any.
+// Having to evict recent failures too is logged when it starts and when it
+// stops, not on every failure. Callers must hold l.mu.
+func (l *authLimiter) pruneLocked(now time.Time) {
+	pruned, evictedRecent := false, false
+	for _, m := range []map[string]*authFailures{l.byIP, l.byUser} {
+		if len(m) < authMaxTrackedKeys {
+			continue
+		}
+		keys := slices.Collect(maps.Keys(m))
+		slices.SortFunc(keys, func(a, b string) int {
+			return m[a].lastFailure.Compare(m[b].lastFailure)
+		})
+		for _, key := range keys[:len(keys)-authPruneTarget] {
+			if now.Sub(m[key].lastFailure) <= authFailureWindow {
+				evictedRecent = true
+			}
+			delete(m, key)
+		}
+		pruned = true
+	}
+	if !pruned || evictedRecent == l.evictingRecent {
+		return
+	}
+	l.evictingRecent = evictedRecent
+	if evictedRecent {
+		slog.Warn("admin auth limiter is full; forgetting the oldest recent failures", "max_keys", authMaxTrackedKeys)
+	} else {
+		slog.Info("admin auth limiter is evicting only stale failures again")
+	}
+}
+
+func limiterUserKey(user string) string {
+	user = strings.ToLower(strings.TrimSpace(user))
+	if len(user) > authMaxUsernameBytes {
+		user = user[:authMaxUsernameBytes]
+	}
+	return user
+}
+
+// clientIP returns the address used for rate limiting. Forwarded headers are
+// only honoured when the server sits behind a trusted proxy such as ngrok,
+// otherwise any client could pick its own key.
+func (s *server) clientIP(r *http.Request) string {
+	if s.trustProxyHeaders {
+		// The proxy appends the address it saw to whatever the client
+		// sent, so only the last entry can be trusted.
+		fwd := r.Header.Values("X-Forwarded-For")
+		if len(fwd) > 0 {
+			entries := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
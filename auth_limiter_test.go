@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthLimiterLocksAfterThreshold(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newAuthLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < authFailureThreshold-1; i++ {
+		l.recordFailure("192.0.2.1", "admin")
+	}
+	if wait := l.lockedFor("192.0.2.2", "admin"); wait != 0 {
+		t.Fatalf("locked after %d failures: %v", authFailureThreshold-1, wait)
+	}
+	l.recordFailure("192.0.2.1", "Admin ")
+	if wait := l.lockedFor("192.0.2.2", "admin"); wait != authBaseLockout {
+		t.Fatalf("lockedFor = %v, want %v", wait, authBaseLockout)
+	}
+	l.recordSuccess("192.0.2.1", "admin")
+	if wait := l.lockedFor("192.0.2.1", "admin"); wait != 0 {
+		t.Fatalf("still locked after success: %v", wait)
+	}
+}
+
+// A full limiter must evict stale entries before the username under
+// attack when the attacker cycles through random usernames in between.
+func TestAuthLimiterPruneKeepsRecentFailures(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newAuthLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < authMaxTrackedKeys/2; i++ {
+		l.byUser[fmt.Sprintf("old-%d", i)] = &authFailures{count: 1, lastFailure: now.Add(-authFailureWindow - time.Duration(i+1)*time.Second)}
+		l.byUser[fmt.Sprintf("recent-%d", i)] = &authFailures{count: 1, lastFailure: now.Add(-time.Minute)}
+	}
+	for i := 0; i < authFailureThreshold-1; i++ {
+		ip := fmt.Sprintf("198.51.100.%d", i)
+		l.recordFailure(ip, "admin")
+		for j := 0; j < 3; j++ {
+			l.recordFailure(ip, fmt.Sprintf("random-%d-%d", i, j))
+		}
+		now = now.Add(time.Minute)
+	}
+	l.recordFailure("198.51.100.200", "admin")
+	if wait := l.lockedFor("203.0.113.1", "admin"); wait == 0 {
+		t.Fatal("admin was not locked out; its failures were evicted")
+	}
+	if len(l.byUser) > authMaxTrackedKeys {
+		t.Fatalf("tracking %d usernames, want at most %d while stale ones remain", len(l.byUser), authMaxTrackedKeys)
+	}
+	if _, ok := l.byUser[fmt.Sprintf("old-%d", authMaxTrackedKeys/2-1)]; ok {
+		t.Fatal("the oldest stale username was not evicted first")
+	}
+	if _, ok := l.byUser["old-0"]; !ok {
+		t.Fatal("evicted more stale usernames than needed")
+	}
+}
+
+// A flood of recent failures still cannot grow the maps past the cap, and
+// the eviction of recent failures is logged once rather than per failure.
+func TestAuthLimiterCapsRecentFailures(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newAuthLimiter()
+	l.now = func() time.Time { return now }
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	for i := 0; i < authMaxTrackedKeys; i++ {
+		l.byUser[fmt.Sprintf("user-%d", i)] = &authFailures{count: 1, lastFailure: now.Add(-time.Minute + time.Duration(i)*time.Millisecond)}
+	}
+	for i := 0; i < 3*(authMaxTrackedKeys-authPruneTarget); i++ {
+		l.recordFailure("192.0.2.1", fmt.Sprintf("flood-%d", i))
+		now = now.Add(time.Millisecond)
+	}
+	if len(l.byUser) > authMaxTrackedKeys {
+		t.Fatalf("tracking %d usernames, want at most %d", len(l.byUser), authMaxTrackedKeys)
+	}
+	if _, ok := l.byUser["user-0"]; ok {
+		t.Error("the oldest username was not evicted")
+	}
+	if _, ok := l.byUser[fmt.Sprintf("flood-%d", 3*(authMaxTrackedKeys-authPruneTarget)-1)]; !ok {
+		t.Error("the latest failure was evicted")
+	}
+	if n := strings.Count(logs.String(), "limiter is full"); n != 1 {
+		t.Errorf("logged a full limiter %d times, want once:\n%s", n, logs.String())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		trust  bool
+		header http.Header
+		want   string
+	}{
+		{"remote address", false, nil, "192.0.2.1"},
+		{"untrusted header", false, http.Header{"X-Forwarded-For": {"203.0.113.9"}}, "192.0.2.1"},
+		{"proxy entry", true, http.Header{"X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"spoofed entries before the proxy's", true, http.Header{"X-Forwarded-For": {"198.51.100.1, 198.51.100.2,203.0.113.9"}}, "203.0.113.9"},
+		{"repeated header", true, http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.9"}}, "203.0.113.9"},
+		{"real IP", true, http.Header{"X-Real-Ip": {"203.0.113.7"}}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{trustProxyHeaders: tt.trust}
+			r := httptest.NewRequest(http.MethodPost, "/admin/login", nil)
+			r.RemoteAddr = "192.0.2.1:51234"
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/color"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-pdf/fpdf"
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	bookFontFamily   = "book"
+	bookQRSize       = 22.0 // mm
+	bookQRPixels     = 256
+	defaultBookTitle = "Our Guestbook"
+)
+
+type rgb struct{ r, g, b int }
+
+// bookTheme is the look of the keepsake PDF. Sizes are in points, margins
+// in millimetres.
+type bookTheme struct {
+	paper      rgb
+	ink        rgb
+	muted      rgb
+	accent     rgb
+	titleSize  float64
+	nameSize   float64
+	bodySize   float64
+	lineHeight float64 // multiple of the body size
+	margin     float64
+	rule       bool // a line between entries
+	centered   bool // names and entries centred, as on a printed card
+}
+
+var bookThemes = map[string]bookTheme{
+	"classic": {
+		paper: rgb{253, 250, 243}, ink: rgb{51, 41, 33}, muted: rgb{140, 124, 108}, accent: rgb{150, 110, 60},
+		titleSize: 34, nameSize: 13, bodySize: 11.5, lineHeight: 1.5, margin: 24, rule: true, centered: true,
+	},
+	"modern": {
+		paper: rgb{255, 255, 255}, ink: rgb{17, 24, 39}, muted: rgb{107, 114, 128}, accent: rgb{219, 39, 119},
+		titleSize: 40, nameSize: 14, bodySize: 11, lineHeight: 1.45, margin: 20,
+	},
+	"minimal": {
+		paper: rgb{255, 255, 255}, ink: rgb{0, 0, 0}, muted: rgb{110, 110, 110}, accent: rgb{0, 0, 0},
+		titleSize: 28, nameSize: 11, bodySize: 10, lineHeight: 1.4, margin: 18,
+	},
+}
+
+// bookFonts are TrueType files embedded in the PDF. The Go fonts cover
+// Latin, Greek and Cyrillic; set book.font (and optionally book.font_bold
+// and book.font_italic) to a font such as Noto Sans CJK for other scripts.
+type bookFonts struct {
+	regular, bold, italic []byte
+}
+
+func loadBookFonts(c *config) (bookFonts, error) {
+	fonts := bookFonts{regular: goregular.TTF, bold: gobold.TTF, italic: goitalic.TTF}
+	if c.Book.Font == "" {
+		return fonts, nil
+	}
+	read := func(path, env string) ([]byte, error) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", setting(env), err)
+		}
+		return raw, nil
+	}
+	var err error
+	if fonts.regular, err = read(c.Book.Font, "BOOK_FONT"); err != nil {
+		return fonts, err
+	}
+	// A single file is used for every style unless the others are given.
+	fonts.bold, fonts.italic = fonts.regular, fonts.regular
+	if c.Book.FontBold != "" {
+		if fonts.bold, err = read(c.Book.FontBold, "BOOK_FONT_BOLD"); err != nil {
+			return fonts, err
+		}
+	}
+	if c.Book.FontItalic != "" {
+		if fonts.italic, err = read(c.Book.FontItalic, "BOOK_FONT_ITALIC"); err != nil {
+			return fonts, err
+		}
+	}
+	return fonts, nil
+}
+
+// bookEntry is one message or voice note on the page.
+type bookEntry struct {
+	guestName  string
+	tableLabel string
+	createdAt  time.Time
+	text       string
+	voice      *voiceMessageMetadata
+	audioURL   string
+}
+
+// bookOptions are the query parameters of /admin/book.pdf.
+type bookOptions struct {
+	theme     bookTheme
+	title     string
+	subtitle  string
+	paper     string
+	order     string
+	location  *time.Location
+	qr        bool
+	audioBase string
+}
+
+func parseBookOptions(r *http.Request) (bookOptions, error) {
+	q := r.URL.Query()
+	opts := bookOptions{
+		title:     strings.TrimSpace(q.Get("title")),
+		subtitle:  strings.TrimSpace(q.Get("subtitle")),
+		order:     q.Get("order"),
+		audioBase: strings.TrimSuffix(strings.TrimSpace(q.Get("audio_base")), "/"),
+		location:  time.Local,
+	}
+	name := q.Get("theme")
+	if name == "" {
+		name = "classic"
+	}
+	theme, ok := bookThemes[name]
+	if !ok {
+		return opts, fmt.Errorf("unknown theme %q", name)
+	}
+	opts.theme = theme
+	if opts.title == "" {
+		opts.title = defaultBookTitle
+	}
+	switch opts.order {
+	case "":
+		opts.order = "chronological"
+	case "chronological", "alphabetical":
+	default:
+		return opts, fmt.Errorf("order must be chronological or alphabetical")
+	}
+	switch strings.ToLower(q.Get("paper")) {
+	case "", "a4":
+		opts.paper = "A4"
+	case "letter":
+		opts.paper = "Letter"
+	case "a5":
+		opts.paper = "A5"
+	default:
+		return opts, fmt.Errorf("paper must be a4, a5 or letter")
+	}
+	if tz := q.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return opts, fmt.Errorf("unknown tz %q", tz)
+		}
+		opts.location = loc
+	}
+	if opts.audioBase != "" && !strings.HasPrefix(opts.audioBase, "https://") && !strings.HasPrefix(opts.audioBase, "http://") {
+		return opts, fmt.Errorf("audio_base must be an http(s) URL")
+	}
+	// The clips on this server need an admin sign-in, so a QR code has to
+	// point at a public copy of them.
+	switch q.Get("qr") {
+	case "":
+		opts.qr = opts.audioBase != ""
+	case "true":
+		if opts.audioBase == "" {
+			return opts, fmt.Errorf("qr needs audio_base, the public URL of the archive's voice folder")
+		}
+		opts.qr = true
+	case "false":
+	default:
+		return opts, fmt.Errorf("qr must be true or false")
+	}
+	return opts, nil
+}
+
+// handleBook renders GET /admin/book.pdf, a printable keepsake of every
+// approved message and voice note: a cover page, then one entry after
+// another. Voice notes show their note and transcript, with a QR code
+// that plays the clip when audio_base is given.
+func (s *server) handleBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := parseBookOptions(r)
+	if err != nil {
+		clientError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entries, err := s.loadBookEntries(ctx, opts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query book entries", "err", err)
+		serverError(w, r, "failed to build book")
+		return
+	}
+	var buf bytes.Buffer
+	if err := renderBook(&buf, s.bookFonts, opts, entries); err != nil {
+		slog.ErrorContext(r.Context(), "render book", "err", err)
+		serverError(w, r, "failed to build book")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="guestbook.pdf"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		slog.ErrorContext(r.Context(), "write book", "err", err)
+	}
+}
+
+// loadBookEntries returns approved messages and voice notes in book order.
+// Alphabetical order follows Unicode collation, so accented and non-Latin
+// names sort where a reader expects them.
+func (s *server) loadBookEntries(ctx context.Context, opts bookOptions) ([]bookEntry, error) {
+	var entries []bookEntry
+	rows, err := s.pool.Query(ctx, `SELECT `+messageColumns+` FROM messages WHERE approved ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, bookEntry{guestName: m.GuestName, tableLabel: m.TableLabel, createdAt: m.CreatedAt, text: m.Text})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.pool.Query(ctx, `SELECT `+voiceMessageColumns+` FROM voice_messages WHERE approved ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		vm, err := scanVoiceMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		// The QR code points at the clip's file from /admin/archive.zip
+		// uploaded under audio_base.
+		var audioURL string
+		if opts.audioBase != "" {
+			audioURL = opts.audioBase + "/" + strings.TrimPrefix(voiceArchiveName(vm), "voice/")
+		}
+		entries = append(entries, bookEntry{guestName: vm.GuestName, tableLabel: vm.TableLabel, createdAt: vm.CreatedAt, voice: &vm, audioURL: audioURL})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.order == "alphabetical" {
+		c := collate.New(language.Und, collate.IgnoreCase)
+		slices.SortStableFunc(entries, func(a, b bookEntry) int {
+			if n := c.CompareString(bookName(a), bookName(b)); n != 0 {
+				return n
+			}
+			return a.createdAt.Compare(b.createdAt)
+		})
+	} else {
+		slices.SortStableFunc(entries, func(a, b bookEntry) int { return a.createdAt.Compare(b.createdAt) })
+	}
+	return entries, nil
+}
+
+func bookName(e bookEntry) string {
+	if name := strings.TrimSpace(e.guestName); name != "" {
+		return name
+	}
+	return "Anonymous"
+}
+
+// bookInitial is the letter an alphabetical book files name under: its
+// first letter without accents, since the sort ignores them too and would
+// otherwise put Élise under her own heading between Edward and Emma.
+func bookInitial(name string) string {
+	for _, r := range norm.NFD.String(name) {
+		if !unicode.Is(unicode.Mn, r) {
+			return string(unicode.ToUpper(r))
+		}
+	}
+	return ""
+}
+
+// renderBook typesets the PDF.
+func renderBook(out *bytes.Buffer, fonts bookFonts, opts bookOptions, entries []bookEntry) error {
+	t := opts.theme
+	pdf := fpdf.New("P", "mm", opts.paper, "")
+	pdf.SetTitle(opts.title, true)
+	pdf.SetCreator("The GuestBook", true)
+	pdf.AddUTF8FontFromBytes(bookFontFamily, "", fonts.regular)
+	pdf.AddUTF8FontFromBytes(bookFontFamily, "B", fonts.bold)
+	pdf.AddUTF8FontFromBytes(bookFontFamily, "I", fonts.italic)
+	pdf.SetMargins(t.margin, t.margin, t.margin)
+	pdf.SetAutoPageBreak(true, t.margin+8)
+	pdf.AliasNbPages("")
+	pageW, pageH := pdf.GetPageSize()
+	width := pageW - 2*t.margin
+	align := "L"
+	if t.centered {
+		align = "C"
+	}
+
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFillColor(t.paper.r, t.paper.g, t.paper.b)
+		pdf.Rect(0, 0, pageW, pageH, "F")
+	})
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-t.margin)
+		pdf.SetFont(bookFontFamily, "", 8)
+		pdf.SetTextColor(t.muted.r, t.muted.g, t.muted.b)
+		pdf.CellFormat(0, 5, strconv.Itoa(pdf.PageNo()-1), "", 0, "C", false, 0, "")
+	})
+
+	// Cover.
+	pdf.AddPage()
+	pdf.SetY(pageH * 0.35)
+	pdf.SetFont(bookFontFamily, "B", t.titleSize)
+	pdf.SetTextColor(t.accent.r, t.accent.g, t.accent.b)
+	pdf.MultiCell(width, t.titleSize*0.5, opts.title, "", "C", false)
+	pdf.Ln(6)
+	pdf.SetFont(bookFontFamily, "", t.bodySize+3)
+	pdf.SetTextColor(t.ink.r, t.ink.g, t.ink.b)
+	if opts.subtitle != "" {
+		pdf.MultiCell(width, (t.bodySize+3)*0.55, opts.subtitle, "", "C", false)
+		pdf.Ln(4)
+	}
+	pdf.SetFont(bookFontFamily, "", t.bodySize)
+	pdf.SetTextColor(t.muted.r, t.muted.g, t.muted.b)
+	if len(entries) > 0 {
+		first, last := entries[0].createdAt, entries[0].createdAt
+		for _, e := range entries {
+			first, last = minTime(first, e.createdAt), maxTime(last, e.createdAt)
+		}
+		pdf.CellFormat(width, 6, bookDateRange(first.In(opts.location), last.In(opts.location)), "", 1, "C", false, 0, "")
+	}
+	pdf.CellFormat(width, 6, bookCount(entries), "", 1, "C", false, 0, "")
+
+	pdf.AddPage()
+	lineH := t.bodySize * t.lineHeight * 0.3528 // points to mm
+	initial := ""
+	sameInitial := collate.New(language.Und, collate.Loose)
+	for i, e := range entries {
+		name := bookName(e)
+		if opts.order == "alphabetical" {
+			if first := bookInitial(name); sameInitial.CompareString(first, initial) != 0 {
+				initial = first
+				ensureSpace(pdf, 20+3*lineH, pageH, t.margin+8)
+				pdf.SetFont(bookFontFamily, "B", t.nameSize+6)
+				pdf.SetTextColor(t.accent.r, t.accent.g, t.accent.b)
+				pdf.CellFormat(width, 12, initial, "", 1, align, false, 0, "")
+			}
+		}
+
+		// Keep short entries on one page; long ones may still flow.
+		pdf.SetFont(bookFontFamily, "", t.bodySize)
+		body := e.text
+		if e.voice != nil {
+			body = e.voice.Note
+		}
+		need := 10 + float64(len(pdf.SplitText(body, width)))*lineH
+		if e.voice != nil && opts.qr {
+			need += bookQRSize + 4
+		}
+		ensureSpace(pdf, min(need, pageH/2), pageH, t.margin+8)
+		if i > 0 && t.rule && pdf.GetY() > t.margin+1 {
+			pdf.SetDrawColor(t.muted.r, t.muted.g, t.muted.b)
+			pdf.SetLineWidth(0.2)
+			y := pdf.GetY()
+			pdf.Line(pageW/2-15, y, pageW/2+15, y)
+			pdf.Ln(4)
+		}
+
+		pdf.SetFont(bookFontFamily, "B", t.nameSize)
+		pdf.SetTextColor(t.ink.r, t.ink.g, t.ink.b)
+		pdf.MultiCell(width, t.nameSize*0.45, name, "", align, false)
+		pdf.SetFont(bookFontFamily, "", t.bodySize-2)
+		pdf.SetTextColor(t.muted.r, t.muted.g, t.muted.b)
+		meta := e.createdAt.In(opts.location).Format("2 January 2006, 15:04")
+		if e.tableLabel != "" {
+			meta += " · " + e.tableLabel
+		}
+		if e.voice != nil {
+			meta += fmt.Sprintf(" · voice note, %d s", e.voice.DurationSeconds)
+		}
+		pdf.CellFormat(width, 5, meta, "", 1, align, false, 0, "")
+		pdf.Ln(1)
+
+		pdf.SetFont(bookFontFamily, "", t.bodySize)
+		pdf.SetTextColor(t.ink.r, t.ink.g, t.ink.b)
+		if body != "" {
+			pdf.MultiCell(width, lineH, body, "", align, false)
+		}
+		if e.voice != nil && e.voice.Transcript != "" {
+			pdf.SetFont(bookFontFamily, "I", t.bodySize)
+			pdf.MultiCell(width, lineH, "“"+e.voice.Transcript+"”", "", align, false)
+		}
+		if e.voice != nil && opts.qr {
+			if err := bookQRCode(pdf, e, t, align, width); err != nil {
+				return err
+			}
+		}
+		pdf.Ln(6)
+	}
+	if len(entries) == 0 {
+		pdf.SetFont(bookFontFamily, "I", t.bodySize)
+		pdf.SetTextColor(t.muted.r, t.muted.g, t.muted.b)
+		pdf.CellFormat(width, 10, "No approved messages yet.", "", 1, "C", false, 0, "")
+	}
+	return pdf.Output(out)
+}
+
+// bookQRCode places a scannable, clickable code linking to the clip.
+func bookQRCode(pdf *fpdf.Fpdf, e bookEntry, t bookTheme, align string, width float64) error {
+	code, err := qrcode.New(e.audioURL, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	code.BackgroundColor = colorRGB(t.paper)
+	code.ForegroundColor = colorRGB(t.ink)
+	png, err := code.PNG(bookQRPixels)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("qr-%d", e.voice.ID)
+	pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+	left, _, _, _ := pdf.GetMargins()
+	x := left
+	if align == "C" {
+		x = left + (width-bookQRSize)/2
+	}
+	pdf.Ln(2)
+	y := pdf.GetY()
+	pdf.ImageOptions(name, x, y, bookQRSize, bookQRSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, e.audioURL)
+	pdf.SetY(y + bookQRSize)
+	pdf.SetFont(bookFontFamily, "", 7)
+	pdf.SetTextColor(t.muted.r, t.muted.g, t.muted.b)
+	pdf.CellFormat(width, 4, "Scan to listen", "", 1, align, false, 0, "")
+	return pdf.Error()
+}
+
+// ensureSpace starts a new page unless need millimetres fit above the
+// bottom margin.
+func ensureSpace(pdf *fpdf.Fpdf, need, pageH, bottom float64) {
+	if pdf.GetY()+need > pageH-bottom {
+		pdf.AddPage()
+	}
+}
+
+func bookDateRange(first, last time.Time) string {
+	if first.Format("2006-01-02") == last.Format("2006-01-02") {
+		return first.Format("2 January 2006")
+	}
+	return first.Format("2 January 2006") + " – " + last.Format("2 January 2006")
+}
+
+func bookCount(entries []bookEntry) string {
+	var messages, voices int
+	for _, e := range entries {
+		if e.voice != nil {
+			voices++
+		} else {
+			messages++
+		}
+	}
+	plural := func(n int, word string) string {
+		if n == 1 {
+			return "1 " + word
+		}
+		return strconv.Itoa(n) + " " + word + "s"
+	}
+	return plural(messages, "message") + " · " + plural(voices, "voice note")
+}
+
+func colorRGB(c rgb) color.Color {
+	return color.RGBA{uint8(c.r), uint8(c.g), uint8(c.b), 255}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
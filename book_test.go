@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBookInitial(t *testing.T) {
+	tests := map[string]string{
+		"Edward":  "E",
+		"Élise":   "E",
+		"élise":   "E",
+		"Émile":  "E",
+		"Øystein": "Ø",
+		"Ängla":   "A",
+		"Şule":    "S",
+		"Ярослав": "Я",
+		"山田":      "山",
+		"":        "",
+	}
+	for name, want := range tests {
+		if got := bookInitial(name); got != want {
+			t.Errorf("bookInitial(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseBookOptionsQR(t *testing.T) {
+	tests := []struct {
+		query   string
+		qr      bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"?qr=false", false, false},
+		{"?qr=true", false, true},
+		{"?qr=yes&audio_base=https://example.com/voice", false, true},
+		{"?audio_base=https://example.com/voice/", true, false},
+		{"?qr=true&audio_base=https://example.com/voice", true, false},
+		{"?qr=false&audio_base=https://example.com/voice", false, false},
+		{"?audio_base=/voice", false, true},
+	}
+	for _, tt := range tests {
+		opts, err := parseBookOptions(httptest.NewRequest("GET", "/admin/book.pdf"+tt.query, nil))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && opts.qr != tt.qr {
+			t.Errorf("%q: qr = %v, want %v", tt.query, opts.qr, tt.qr)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// command is one subcommand of the binary. Every command parses its own
+// flags; flags that name a setting fall back to the same environment
+// variable the server reads.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned here because the help command lists the table itself.
+	commands = []command{
+		{"serve", "apply migrations and serve HTTP (the default)", runServe},
+		{"migrate", "apply, roll back or list schema migrations: migrate up|down|status", runMigrate},
+		{"admin", "manage API tokens: admin create|list|revoke", runAdmin},
+		{"export", "write messages and voice notes as csv, ndjson, json or a zip archive", runExport},
+		{"import", "restore an archive or export file", runImport},
+		{"site", "write the guestbook as a static website", runSite},
+		{"purge", "delete old jobs, sessions, tokens or content", runPurge},
+		{"check-config", "validate the environment without serving", runCheckConfig},
+		{"healthcheck", "ask a running server whether it is ready", runHealthcheck},
+		{"help", "list commands", runHelp},
+	}
+}
+
+func main() {
+	ctx := context.Background()
+	name, args := "serve", os.Args[1:]
+	// Plain `guestbook` and `guestbook -port 8080` still serve.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, args, os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printCommands(os.Stderr)
+	os.Exit(2)
+}
+
+func runHelp(ctx context.Context, args []string, stdout io.Writer) error {
+	printCommands(stdout)
+	return nil
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun a command with -h for its flags.")
+}
+
+// openDB loads the config and connects for a maintenance command. It
+// refuses to work on a schema that `migrate up` has not brought up to
+// date.
+func openDB(ctx context.Context, opts *configOptions) (*pgxpool.Pool, error) {
+	cfg, err := opts.load()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create db pool: %w", err)
+	}
+	if err := requireCurrentSchema(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// runAdmin implements `admin create|list|revoke` for API tokens, so a
+// token can be minted before anyone can sign in to the dashboard.
+func runAdmin(ctx context.Context, args []string, stdout io.Writer) error {
+	usage := errors.New("usage: admin create -name <name> -scopes <scope,...> [-expires <duration>] | admin list | admin revoke <id>")
+	if len(args) == 0 {
+		return usage
+	}
+	sub, args := args[0], args[1:]
+	flags := flag.NewFlagSet("admin "+sub, flag.ContinueOnError)
+	opts := configFlags(flags)
+	var name, scopes *string
+	var expires *time.Duration
+	switch sub {
+	case "create":
+		name = flags.String("name", "", "token name, e.g. the tool that will use it")
+		scopes = flags.String("scopes", "", "comma-separated scopes: "+strings.Join(allScopes, ", "))
+		expires = flags.Duration("expires", 0, "lifetime, e.g. 720h (default: never expires)")
+	case "list", "revoke":
+	default:
+		return usage
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	pool, err := openDB(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	s := &server{pool: pool}
+
+	switch sub {
+	case "create":
+		if flags.NArg() != 0 {
+			return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		}
+		*name = strings.TrimSpace(*name)
+		if *name == "" {
+			return errors.New("-name is required")
+		}
+		if len([]rune(*name)) > maxTokenNameLength {
+			return errors.New("-name is too long")
+		}
+		var requested []string
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				requested = append(requested, scope)
+			}
+		}
+		valid, err := validTokenScopes(requested)
+		if err != nil {
+			return err
+		}
+		var expiresAt *time.Time
+		if *expires < 0 {
+			return errors.New("-expires must be positive")
+		} else if *expires > 0 {
+			t := time.Now().Add(*expires)
+			expiresAt = &t
+		}
+		tok, raw, err := s.insertAPIToken(ctx, *name, valid, "cli", expiresAt)
+		if err != nil {
+			return err
+		}
+		// The raw token goes to stdout alone so it can be piped.
+		fmt.Fprintf(os.Stderr, "created token %d; it is shown only once:\n", tok.ID)
+		fmt.Fprintln(stdout, raw)
+	case "list":
+		tokens, err := s.listAPITokens(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+		for _, t := range tokens {
+			expiry, status := "never", "active"
+			if t.ExpiresAt != nil {
+				expiry = t.ExpiresAt.Format(time.DateTime)
+				if t.ExpiresAt.Before(time.Now()) {
+					status = "expired"
+				}
+			}
+			if t.RevokedAt != nil {
+				status = "revoked"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s…\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Prefix, strings.Join(t.Scopes, ","), t.CreatedAt.Format(time.DateTime), expiry, status)
+		}
+		return tw.Flush()
+	case "revoke":
+		if flags.NArg() != 1 {
+			return usage
+		}
+		var id int
+		if _, err := fmt.Sscan(flags.Arg(0), &id); err != nil || id <= 0 {
+			return fmt.Errorf("invalid token id %q", flags.Arg(0))
+		}
+		revoked, err := s.revokeAPIToken(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("no active token with id %d", id)
+		}
+		fmt.Fprintln(stdout, "revoked token", id)
+	}
+	return nil
+}
+
+// runCheckConfig implements `check-config [-ping]`: it reads the
+// environment exactly as serve does and reports the first problem.
+func runCheckConfig(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	opts := configFlags(flags)
+	ping := flags.Bool("ping", false, "also connect to the database and check the schema version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	cfg, err := opts.load()
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	srv, err := newServer(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	auth := "none (admin routes are unprotected)"
+	switch {
+	case srv.passwordAuthEnabled() && srv.oidc != nil:
+		auth = "password and OIDC"
+	case srv.passwordAuthEnabled():
+		auth = "password"
+	case srv.oidc != nil:
+		auth = "OIDC"
+	}
+	fmt.Fprintln(stdout, "admin auth:", auth)
+	fmt.Fprintln(stdout, "allowed origins:", strings.Join(corsOptions(cfg.AllowedOrigins).AllowedOrigins, ", "))
+	fmt.Fprintln(stdout, "background jobs:", strings.Join(srv.jobs.kinds(), ", "))
+	if cfg.Loudness.FFmpegBin != "" {
+		fmt.Fprintln(stdout, "voice formats: Opus and WAV in Go, others with", cfg.Loudness.FFmpegBin)
+	} else {
+		fmt.Fprintf(stdout, "voice formats: Opus and WAV only; set %s to normalize and transcribe AAC clips from iPhones\n", setting("FFMPEG_BIN"))
+	}
+	fmt.Fprintf(stdout, "limits: %+v\n", srv.limits)
+	for _, name := range slices.Sorted(maps.Keys(srv.eventLimits)) {
+		fmt.Fprintf(stdout, "limits for event %q: %+v\n", name, srv.eventLimits[name])
+	}
+	if *ping {
+		pool, err := openDB(ctx, opts)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+		fmt.Fprintln(stdout, "database: reachable, schema up to date")
+	}
+	fmt.Fprintln(stdout, "config OK")
+	return nil
+}
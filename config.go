@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// config is every deployment setting. It starts from defaultConfig, is
+// overlaid by a YAML or TOML file when one is given, and then by the
+// environment variable named in each field's env tag; empty variables are
+// ignored. validate reports every problem at once.
+type config struct {
+	Port              string `yaml:"port" toml:"port" env:"PORT"`
+	DatabaseURL       string `yaml:"database_url" toml:"database_url" env:"DATABASE_URL"`
+	AllowedOrigins    string `yaml:"allowed_origins" toml:"allowed_origins" env:"ALLOWED_ORIGINS"`
+	TrustProxyHeaders bool   `yaml:"trust_proxy_headers" toml:"trust_proxy_headers" env:"TRUST_PROXY_HEADERS"`
+
+	Log struct {
+		Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+		Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	} `yaml:"log" toml:"log"`
+
+	// HTTP bounds how long one connection may take. Read and write cover
+	// whole requests, so they must fit the largest video upload and the
+	// archive download on a slow phone connection.
+	HTTP struct {
+		ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+		ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+		WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+		IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+		ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+		// ReadyRequiresFrontend makes /readyz fail without a frontend
+		// build; turn it off when the frontend is hosted elsewhere.
+		ReadyRequiresFrontend bool `yaml:"ready_requires_frontend" toml:"ready_requires_frontend" env:"READY_REQUIRES_FRONTEND"`
+	} `yaml:"http" toml:"http"`
+
+	Admin struct {
+		Username string `yaml:"username" toml:"username" env:"ADMIN_USERNAME"`
+		Password string `yaml:"password" toml:"password" env:"ADMIN_PASSWORD"`
+	} `yaml:"admin" toml:"admin"`
+
+	Sessions struct {
+		Secret         string        `yaml:"secret" toml:"secret" env:"SESSION_SECRET"`
+		TTL            time.Duration `yaml:"ttl" toml:"ttl" env:"SESSION_TTL"`
+		CookieSecure   string        `yaml:"cookie_secure" toml:"cookie_secure" env:"SESSION_COOKIE_SECURE"`
+		CookieSameSite string        `yaml:"cookie_samesite" toml:"cookie_samesite" env:"SESSION_COOKIE_SAMESITE"`
+	} `yaml:"sessions" toml:"sessions"`
+
+	Invites struct {
+		Secret    string `yaml:"secret" toml:"secret" env:"INVITE_SECRET"`
+		Required  bool   `yaml:"required" toml:"required" env:"INVITES_REQUIRED"`
+		EventName string `yaml:"event_name" toml:"event_name" env:"EVENT_NAME"`
+		PublicURL string `yaml:"public_url" toml:"public_url" env:"PUBLIC_URL"`
+	} `yaml:"invites" toml:"invites"`
+
+	OIDC struct {
+		IssuerURL         string `yaml:"issuer_url" toml:"issuer_url" env:"OIDC_ISSUER_URL"`
+		ClientID          string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+		ClientSecret      string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+		RedirectURL       string `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+		PostLoginRedirect string `yaml:"post_login_redirect" toml:"post_login_redirect" env:"OIDC_POST_LOGIN_REDIRECT"`
+		Scopes            string `yaml:"scopes" toml:"scopes" env:"OIDC_SCOPES"`
+		GroupsClaim       string `yaml:"groups_claim" toml:"groups_claim" env:"OIDC_GROUPS_CLAIM"`
+		AllowedEmails     string `yaml:"allowed_emails" toml:"allowed_emails" env:"OIDC_ALLOWED_EMAILS"`
+		AllowedGroups     string `yaml:"allowed_groups" toml:"allowed_groups" env:"OIDC_ALLOWED_GROUPS"`
+	} `yaml:"oidc" toml:"oidc"`
+
+	GuestEditWindow time.Duration    `yaml:"guest_edit_window" toml:"guest_edit_window" env:"GUEST_EDIT_WINDOW"`
+	Limits          submissionLimits `yaml:"limits" toml:"limits"`
+	// Events overrides Limits for submissions whose invite names the
+	// event; fields left out keep the deployment value.
+	Events map[string]submissionLimits `yaml:"events" toml:"events"`
+
+	Loudness struct {
+		TargetLUFS float64 `yaml:"target_lufs" toml:"target_lufs" env:"LOUDNESS_TARGET_LUFS"`
+		FFmpegBin  string  `yaml:"ffmpeg_bin" toml:"ffmpeg_bin" env:"FFMPEG_BIN"`
+	} `yaml:"loudness" toml:"loudness"`
+
+	Transcription struct {
+		Transcriber     string        `yaml:"transcriber" toml:"transcriber" env:"TRANSCRIBER"`
+		Timeout         time.Duration `yaml:"timeout" toml:"timeout" env:"TRANSCRIBE_TIMEOUT"`
+		WhisperBin      string        `yaml:"whisper_bin" toml:"whisper_bin" env:"WHISPER_BIN"`
+		WhisperModel    string        `yaml:"whisper_model" toml:"whisper_model" env:"WHISPER_MODEL"`
+		WhisperLanguage string        `yaml:"whisper_language" toml:"whisper_language" env:"WHISPER_LANGUAGE"`
+	} `yaml:"transcription" toml:"transcription"`
+
+	Book struct {
+		Font       string `yaml:"font" toml:"font" env:"BOOK_FONT"`
+		FontBold   string `yaml:"font_bold" toml:"font_bold" env:"BOOK_FONT_BOLD"`
+		FontItalic string `yaml:"font_italic" toml:"font_italic" env:"BOOK_FONT_ITALIC"`
+	} `yaml:"book" toml:"book"`
+}
+
+// submissionLimits bound what a guest can send. Zero in an event override
+// means "same as the deployment".
+type submissionLimits struct {
+	MaxMessageLength        int           `yaml:"max_message_length" toml:"max_message_length" env:"MAX_MESSAGE_LENGTH"`
+	MaxNameLength           int           `yaml:"max_name_length" toml:"max_name_length" env:"MAX_NAME_LENGTH"`
+	MaxAudioBytes           int64         `yaml:"max_audio_bytes" toml:"max_audio_bytes" env:"MAX_AUDIO_BYTES"`
+	MaxAudioDurationSeconds int           `yaml:"max_audio_duration_seconds" toml:"max_audio_duration_seconds" env:"MAX_AUDIO_DURATION_SECONDS"`
+	MaxVideoDuration        time.Duration `yaml:"max_video_duration" toml:"max_video_duration" env:"VIDEO_MAX_DURATION"`
+	MaxVideoMB              int           `yaml:"max_video_mb" toml:"max_video_mb" env:"VIDEO_MAX_MB"`
+}
+
+func defaultConfig() *config {
+	cfg := &config{
+		Port:            defaultPort,
+		DatabaseURL:     defaultDatabaseURL,
+		AllowedOrigins:  "*",
+		GuestEditWindow: defaultGuestEditWindow,
+		Limits: submissionLimits{
+			MaxMessageLength:        defaultMaxMessageLength,
+			MaxNameLength:           defaultMaxNameLength,
+			MaxAudioBytes:           defaultMaxAudioBytes,
+			MaxAudioDurationSeconds: defaultMaxAudioDurationSeconds,
+			MaxVideoDuration:        defaultMaxVideoDuration,
+			MaxVideoMB:              defaultMaxVideoMB,
+		},
+	}
+	cfg.Log.Format = "text"
+	cfg.Log.Level = "info"
+	cfg.HTTP.ReadHeaderTimeout = 10 * time.Second
+	cfg.HTTP.ReadTimeout = 5 * time.Minute
+	cfg.HTTP.WriteTimeout = 10 * time.Minute
+	cfg.HTTP.IdleTimeout = 2 * time.Minute
+	cfg.HTTP.ShutdownTimeout = 30 * time.Second
+	cfg.HTTP.ReadyRequiresFrontend = true
+	cfg.Sessions.TTL = defaultSessionTTL
+	cfg.Sessions.CookieSecure = "auto"
+	cfg.Sessions.CookieSameSite = "lax"
+	cfg.Invites.EventName = "wedding"
+	cfg.OIDC.PostLoginRedirect = "/"
+	cfg.OIDC.Scopes = "openid email profile"
+	cfg.OIDC.GroupsClaim = "groups"
+	cfg.Loudness.TargetLUFS = -23
+	cfg.Transcription.Timeout = 5 * time.Minute
+	cfg.Transcription.WhisperBin = "whisper-cli"
+	cfg.Transcription.WhisperLanguage = "auto"
+	return cfg
+}
+
+// loadConfig reads path (.yaml, .yml or .toml; empty for none), applies
+// the environment and validates the result.
+func loadConfig(path string) (*config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile rejects unknown keys, so a misspelt setting is an error rather
+// than silently ignored.
+func (c *config) readFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(raw), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown setting %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("%s: config file must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// applyEnv sets every field that has a non-empty environment variable.
+func (c *config) applyEnv() error {
+	var errs []error
+	walkSettings(reflect.ValueOf(c).Elem(), "", func(field reflect.Value, key, env string) {
+		raw := os.Getenv(env)
+		if raw == "" {
+			return
+		}
+		if err := setSetting(field, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", env, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func setSetting(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", raw)
+		}
+		field.SetBool(v)
+	case int, int64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("want a whole number, got %q", raw)
+		}
+		field.SetInt(v)
+	case float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("want a number, got %q", raw)
+		}
+		field.SetFloat(v)
+	case time.Duration:
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("want a duration such as 90s or 15m, got %q", raw)
+		}
+		field.SetInt(int64(v))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// walkSettings calls fn for every field of v with an env tag, passing the
+// file key as a dotted path.
+func walkSettings(v reflect.Value, prefix string, fn func(field reflect.Value, key, env string)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if env := f.Tag.Get("env"); env != "" {
+			fn(v.Field(i), key, env)
+		} else if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeFor[time.Duration]() {
+			walkSettings(v.Field(i), key+".", fn)
+		}
+	}
+}
+
+var settingKeys = func() map[string]string {
+	keys := map[string]string{}
+	walkSettings(reflect.ValueOf(&config{}).Elem(), "", func(_ reflect.Value, key, env string) {
+		keys[env] = key
+	})
+	return keys
+}()
+
+// setting names a setting by its file key and environment variable for
+// error messages, e.g. "sessions.ttl (SESSION_TTL)".
+func setting(env string) string {
+	if key, ok := settingKeys[env]; ok {
+		return key + " (" + env + ")"
+	}
+	return env
+}
+
+// validate checks values without touching the filesystem or network;
+// newServer checks the binaries, models and fonts the settings point to.
+func (c *config) validate() error {
+	var errs []error
+	fail := func(env, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s %s", setting(env), fmt.Sprintf(format, args...)))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		fail("PORT", "must be a port number, got %q", c.Port)
+	}
+	if c.DatabaseURL == "" {
+		fail("DATABASE_URL", "is required")
+	}
+	if f := strings.ToLower(c.Log.Format); f != "text" && f != "json" {
+		fail("LOG_FORMAT", "must be text or json, got %q", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("LOG_LEVEL", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	for _, t := range []struct {
+		env string
+		d   time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", c.HTTP.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.HTTP.IdleTimeout},
+		{"HTTP_SHUTDOWN_TIMEOUT", c.HTTP.ShutdownTimeout},
+	} {
+		if t.d <= 0 {
+			fail(t.env, "must be positive")
+		}
+	}
+	if c.Sessions.TTL <= 0 {
+		fail("SESSION_TTL", "must be positive")
+	}
+	if s := strings.ToLower(c.Sessions.CookieSecure); s != "auto" && s != "true" && s != "false" {
+		fail("SESSION_COOKIE_SECURE", "must be auto, true or false, got %q", c.Sessions.CookieSecure)
+	}
+	if s := strings.ToLower(c.Sessions.CookieSameSite); s != "lax" && s != "strict" && s != "none" {
+		fail("SESSION_COOKIE_SAMESITE", "must be lax, strict or none, got %q", c.Sessions.CookieSameSite)
+	}
+	if c.Invites.Required && c.Invites.Secret == "" {
+		fail("INVITES_REQUIRED", "needs %s", setting("INVITE_SECRET"))
+	}
+	if c.OIDC.IssuerURL != "" || c.OIDC.ClientID != "" {
+		if _, err := oidcConfigFrom(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.GuestEditWindow < 0 {
+		fail("GUEST_EDIT_WINDOW", "must not be negative (0 turns guest edits off)")
+	}
+	errs = append(errs, c.Limits.validate("limits", false)...)
+	for name, limits := range c.Events {
+		errs = append(errs, limits.validate("events."+name, true)...)
+	}
+	if c.Loudness.TargetLUFS > 0 || c.Loudness.TargetLUFS < -70 {
+		fail("LOUDNESS_TARGET_LUFS", "must be between -70 and 0, got %v", c.Loudness.TargetLUFS)
+	}
+	if c.Transcription.Timeout <= 0 {
+		fail("TRANSCRIBE_TIMEOUT", "must be positive")
+	}
+	switch c.Transcription.Transcriber {
+	case "":
+	case "whisper":
+		if c.Transcription.WhisperModel == "" {
+			fail("TRANSCRIBER", "is whisper, which needs %s", setting("WHISPER_MODEL"))
+		}
+	default:
+		fail("TRANSCRIBER", "must be empty or whisper, got %q", c.Transcription.Transcriber)
+	}
+	if c.Book.Font == "" && (c.Book.FontBold != "" || c.Book.FontItalic != "") {
+		fail("BOOK_FONT", "is required when %s or %s is set", setting("BOOK_FONT_BOLD"), setting("BOOK_FONT_ITALIC"))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks l. In an override, zero keeps the deployment value and
+// only negative numbers are wrong.
+func (l submissionLimits) validate(prefix string, override bool) []error {
+	var errs []error
+	check := func(key, env string, value int64) {
+		if value > 0 || (value == 0 && override) {
+			return
+		}
+		name := prefix + "." + key
+		if !override {
+			name = setting(env)
+		}
+		errs = append(errs, fmt.Errorf("%s must be positive", name))
+	}
+	check("max_message_length", "MAX_MESSAGE_LENGTH", int64(l.MaxMessageLength))
+	check("max_name_length", "MAX_NAME_LENGTH", int64(l.MaxNameLength))
+	check("max_audio_bytes", "MAX_AUDIO_BYTES", l.MaxAudioBytes)
+	check("max_audio_duration_seconds", "MAX_AUDIO_DURATION_SECONDS", int64(l.MaxAudioDurationSeconds))
+	check("max_video_duration", "VIDEO_MAX_DURATION", int64(l.MaxVideoDuration))
+	check("max_video_mb", "VIDEO_MAX_MB", int64(l.MaxVideoMB))
+	return errs
+}
+
+// merge returns l with the non-zero fields of override.
+func (l submissionLimits) merge(override submissionLimits) submissionLimits {
+	pick := func(base, o int64) int64 {
+		if o > 0 {
+			return o
+		}
+		return base
+	}
+	return submissionLimits{
+		MaxMessageLength:        int(pick(int64(l.MaxMessageLength), int64(override.MaxMessageLength))),
+		MaxNameLength:           int(pick(int64(l.MaxNameLength), int64(override.MaxNameLength))),
+		MaxAudioBytes:           pick(l.MaxAudioBytes, override.MaxAudioBytes),
+		MaxAudioDurationSeconds: int(pick(int64(l.MaxAudioDurationSeconds), int64(override.MaxAudioDurationSeconds))),
+		MaxVideoDuration:        time.Duration(pick(int64(l.MaxVideoDuration), int64(override.MaxVideoDuration))),
+		MaxVideoMB:              int(pick(int64(l.MaxVideoMB), int64(override.MaxVideoMB))),
+	}
+}
+
+func (l submissionLimits) maxVideoBytes() int64 { return int64(l.MaxVideoMB) << 20 }
+
+// limitsFor returns the limits for a submission made with invite (nil for
+// none), which are its event's when the config has an override for it.
+func (s *server) limitsFor(invite *inviteClaims) submissionLimits {
+	if invite != nil {
+		if l, ok := s.eventLimits[invite.Event]; ok {
+			return l
+		}
+	}
+	return s.limits
+}
+
+// uploadCeiling is the largest upload any event allows. Request bodies are
+// capped with it before the invite, and so the event, is known.
+func (s *server) uploadCeiling() submissionLimits {
+	c := s.limits
+	for _, l := range s.eventLimits {
+		c.MaxAudioBytes = max(c.MaxAudioBytes, l.MaxAudioBytes)
+		c.MaxVideoMB = max(c.MaxVideoMB, l.MaxVideoMB)
+	}
+	return c
+}
+
+// configOptions are the -config and -database-url flags every command
+// takes.
+type configOptions struct {
+	path        string
+	databaseURL string
+}
+
+func configFlags(flags *flag.FlagSet) *configOptions {
+	o := &configOptions{}
+	flags.StringVar(&o.path, "config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file (env CONFIG_FILE); environment variables override it")
+	flags.StringVar(&o.databaseURL, "database-url", "", "Postgres connection string (default: DATABASE_URL, then the config file)")
+	return o
+}
+
+// load reads the config and, once it is valid, switches logging to the
+// configured format and level.
+func (o *configOptions) load() (*config, error) {
+	cfg, err := loadConfig(o.path)
+	if err != nil {
+		return nil, err
+	}
+	if o.databaseURL != "" {
+		cfg.DatabaseURL = o.databaseURL
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level))
+	return cfg, nil
+}
+
+// handleLimits tells the guest page what it may send (GET /limits), for
+// the invite in ?invite= or X-Invite-Token, so the form matches the
+// server's checks for that event.
+func (s *server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Invite-Token")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.URL.Query().Get("invite")
+	if token == "" {
+		token = r.Header.Get(inviteHeaderName)
+	}
+	// A bad invite is reported when the guest submits, not here.
+	invite, _ := s.inviteForSubmission(token)
+	l := s.limitsFor(invite)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"max_message_length":         l.MaxMessageLength,
+		"max_name_length":            l.MaxNameLength,
+		"max_audio_bytes":            l.MaxAudioBytes,
+		"max_audio_duration_seconds": l.MaxAudioDurationSeconds,
+		"max_video_bytes":            l.maxVideoBytes(),
+		"max_video_duration_seconds": int(l.MaxVideoDuration.Seconds()),
+	})
+}
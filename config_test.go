@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearSettingsEnv blanks every setting's environment variable for the
+// test, so the machine's environment does not leak into the config.
+func clearSettingsEnv(t *testing.T) {
+	t.Helper()
+	for env := range settingKeys {
+		t.Setenv(env, "")
+	}
+}
+
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearSettingsEnv(t)
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != defaultPort || cfg.Limits != defaultConfig().Limits || cfg.Sessions.TTL != defaultSessionTTL {
+		t.Errorf("loadConfig(\"\") = port %q, limits %+v, ttl %v; want the defaults", cfg.Port, cfg.Limits, cfg.Sessions.TTL)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"guestbook.yaml": `
+port: "8080"
+limits:
+  max_message_length: 2000
+  max_audio_duration_seconds: 120
+events:
+  reception:
+    max_video_duration: 1m
+`,
+		"guestbook.toml": `
+port = "8080"
+
+[limits]
+max_message_length = 2000
+max_audio_duration_seconds = 120
+
+[events.reception]
+max_video_duration = "1m"
+`,
+	}
+	for name, body := range files {
+		t.Run(name, func(t *testing.T) {
+			clearSettingsEnv(t)
+			cfg, err := loadConfig(writeConfig(t, name, body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != "8080" {
+				t.Errorf("port = %q, want 8080", cfg.Port)
+			}
+			if cfg.Limits.MaxMessageLength != 2000 || cfg.Limits.MaxAudioDurationSeconds != 120 {
+				t.Errorf("limits = %+v, want max_message_length 2000 and max_audio_duration_seconds 120", cfg.Limits)
+			}
+			if cfg.Limits.MaxNameLength != defaultMaxNameLength {
+				t.Errorf("max_name_length = %d, want the default %d", cfg.Limits.MaxNameLength, defaultMaxNameLength)
+			}
+			if got := cfg.Events["reception"].MaxVideoDuration; got != time.Minute {
+				t.Errorf("events.reception.max_video_duration = %v, want 1m", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	for name, body := range map[string]string{
+		"guestbook.yaml": "limits:\n  max_mesage_length: 10\n",
+		"guestbook.toml": "[limits]\nmax_mesage_length = 10\n",
+		"guestbook.json": "{}",
+	} {
+		clearSettingsEnv(t)
+		if _, err := loadConfig(writeConfig(t, name, body)); err == nil {
+			t.Errorf("%s: loaded a config with a misspelt key or unknown format", name)
+		}
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	clearSettingsEnv(t)
+	path := writeConfig(t, "guestbook.yaml", "port: \"8080\"\nlimits:\n  max_message_length: 2000\n")
+	t.Setenv("PORT", "9090")
+	t.Setenv("MAX_AUDIO_BYTES", "4194304")
+	t.Setenv("VIDEO_MAX_DURATION", "45s")
+	t.Setenv("INVITES_REQUIRED", "true")
+	t.Setenv("INVITE_SECRET", "s3cret")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9090" {
+		t.Errorf("port = %q, want PORT to win over the file", cfg.Port)
+	}
+	if cfg.Limits.MaxMessageLength != 2000 {
+		t.Errorf("max_message_length = %d, want 2000 from the file", cfg.Limits.MaxMessageLength)
+	}
+	if cfg.Limits.MaxAudioBytes != 4<<20 || cfg.Limits.MaxVideoDuration != 45*time.Second {
+		t.Errorf("limits = %+v, want the environment's audio bytes and video duration", cfg.Limits)
+	}
+	if !cfg.Invites.Required || cfg.Invites.Secret != "s3cret" {
+		t.Errorf("invites = %+v, want required with the environment's secret", cfg.Invites)
+	}
+}
+
+func TestLoadConfigBadEnv(t *testing.T) {
+	clearSettingsEnv(t)
+	t.Setenv("MAX_NAME_LENGTH", "eighty")
+	t.Setenv("SESSION_TTL", "12")
+	t.Setenv("TRUST_PROXY_HEADERS", "maybe")
+	_, err := loadConfig("")
+	if err == nil {
+		t.Fatal("loaded a config with unparsable variables")
+	}
+	for _, env := range []string{"MAX_NAME_LENGTH", "SESSION_TTL", "TRUST_PROXY_HEADERS"} {
+		if !strings.Contains(err.Error(), env) {
+			t.Errorf("error %q does not name %s", err, env)
+		}
+	}
+}
+
+func TestSubmissionLimitsMerge(t *testing.T) {
+	base := defaultConfig().Limits
+	got := base.merge(submissionLimits{MaxAudioDurationSeconds: 120, MaxVideoDuration: time.Minute})
+	want := base
+	want.MaxAudioDurationSeconds = 120
+	want.MaxVideoDuration = time.Minute
+	if got != want {
+		t.Errorf("merge = %+v, want %+v", got, want)
+	}
+	if got := base.merge(submissionLimits{}); got != base {
+		t.Errorf("merge of an empty override = %+v, want %+v", got, base)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config)
+		want   []string
+	}{
+		{"defaults", func(c *config) {}, nil},
+		{"bad port", func(c *config) { c.Port = "http" }, []string{"port (PORT) must be a port number"}},
+		{"no database", func(c *config) { c.DatabaseURL = "" }, []string{"database_url (DATABASE_URL) is required"}},
+		{"zero limit", func(c *config) { c.Limits.MaxMessageLength = 0 }, []string{"limits.max_message_length (MAX_MESSAGE_LENGTH) must be positive"}},
+		{"large limits", func(c *config) {
+			c.Limits.MaxMessageLength = 5000
+			c.Limits.MaxAudioDurationSeconds = 300
+		}, nil},
+		{"event override keeps zero", func(c *config) {
+			c.Events = map[string]submissionLimits{"reception": {MaxAudioDurationSeconds: 120}}
+		}, nil},
+		{"negative event override", func(c *config) {
+			c.Events = map[string]submissionLimits{"reception": {MaxVideoMB: -1}}
+		}, []string{"events.reception.max_video_mb must be positive"}},
+		{"invites without secret", func(c *config) { c.Invites.Required = true }, []string{"invites.required (INVITES_REQUIRED) needs invites.secret (INVITE_SECRET)"}},
+		{"whisper without model", func(c *config) { c.Transcription.Transcriber = "whisper" }, []string{"needs transcription.whisper_model (WHISPER_MODEL)"}},
+		{"every problem at once", func(c *config) {
+			c.Log.Format = "xml"
+			c.Sessions.TTL = 0
+			c.GuestEditWindow = -time.Minute
+		}, []string{"log.format (LOG_FORMAT)", "sessions.ttl (SESSION_TTL)", "guest_edit_window (GUEST_EDIT_WINDOW)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.change(cfg)
+			err := cfg.validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validate() = nil, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validate() = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
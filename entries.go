@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxEntryPhotos = 4
+	maxEntryMemory = 32 << 20
+)
+
+// entry groups everything one guest sent in a single submission: an
+// optional text message, voice notes, photos and videos. Each item keeps its
+// own row (and approval flag) in its table and points back via entry_id.
+type entry struct {
+	ID            int                    `json:"id"`
+	GuestName     string                 `json:"guest_name"`
+	InviteID      string                 `json:"invite_id"`
+	TableLabel    string                 `json:"table_label"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Message       *message               `json:"message"`
+	VoiceMessages []voiceMessageMetadata `json:"voice_messages"`
+	PhotoMessages []photoMessageMetadata `json:"photo_messages"`
+	VideoMessages []videoMessageMetadata `json:"video_messages"`
+}
+
+// insertWithEntry creates an entry and calls insert to add its items in the
+// same transaction. The single-item endpoints use it too, so every message,
+// voice note, photo and video belongs to an entry.
+func (s *server) insertWithEntry(ctx context.Context, guestName string, invite *inviteClaims, editTokenHash string, insert func(tx pgx.Tx, entryID int) error) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	inviteID, inviteKind, tableLabel, inviteGuest := inviteColumns(invite)
+	const insertEntry = `INSERT INTO entries (guest_name, invite_id, invite_kind, table_label, invite_guest, edit_token_hash) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	var entryID int
+	if err := tx.QueryRow(ctx, insertEntry, guestName, inviteID, inviteKind, tableLabel, inviteGuest, editTokenHash).Scan(&entryID); err != nil {
+		return 0, err
+	}
+	if err := insert(tx, entryID); err != nil {
+		return 0, err
+	}
+	return entryID, tx.Commit(ctx)
+}
+
+// deleteEntryItem removes one item and drops its entry once nothing else
+// is left in it. table must be a trusted constant.
+func (s *server) deleteEntryItem(ctx context.Context, table string, id int) error {
+	var entryID *int
+	err := s.pool.QueryRow(ctx, `DELETE FROM `+table+` WHERE id = $1 RETURNING entry_id`, id).Scan(&entryID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && entryID == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	const pruneEntry = `
+DELETE FROM entries e WHERE e.id = $1
+  AND NOT EXISTS (SELECT 1 FROM messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM voice_messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM photo_messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM video_messages WHERE entry_id = e.id)`
+	_, err = s.pool.Exec(ctx, pruneEntry, *entryID)
+	return err
+}
+
+// handleEntry accepts one submission that may combine a text message, a
+// voice note and up to maxEntryPhotos photos (POST /entry, multipart):
+// name, text, audio + duration + note, photo (repeatable), caption, invite.
+func (s *server) handleEntry(w http.ResponseWriter, r *http.Request) {
+	// Explicit CORS headers for public endpoint
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Invite-Token")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploadCeiling().MaxAudioBytes+maxEntryPhotos*maxPhotoBytes+64*1024)
+	if err := r.ParseMultipartForm(maxEntryMemory); err != nil {
+		s.rejectSubmission(w, r, submissionEntry, payloadRejection(err, "invalid entry payload"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	inviteToken := r.FormValue("invite")
+	if inviteToken == "" {
+		inviteToken = r.Header.Get(inviteHeaderName)
+	}
+	invite, err := s.inviteForSubmission(inviteToken)
+	if err != nil {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectInvite, inviteErrorMessage(err)))
+		return
+	}
+	inviteID, inviteKind, tableLabel, inviteGuest := inviteColumns(invite)
+	limits := s.limitsFor(invite)
+
+	guestName := strings.TrimSpace(r.FormValue("name"))
+	if guestName == "" {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectEmptyName, "name is required"))
+		return
+	}
+	if len([]rune(guestName)) > limits.MaxNameLength {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectTooLong, "name is too long"))
+		return
+	}
+	text := strings.TrimSpace(r.FormValue("text"))
+	if len([]rune(text)) > limits.MaxMessageLength {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectTooLong, "message too long"))
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	if len([]rune(note)) > limits.MaxMessageLength {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectTooLong, "note too long"))
+		return
+	}
+	if len([]rune(caption)) > limits.MaxMessageLength {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectTooLong, "caption too long"))
+		return
+	}
+
+	var voice *voiceUpload
+	if len(r.MultipartForm.File["audio"]) > 0 {
+		if voice, err = parseVoiceUpload(r, limits); err != nil {
+			s.rejectSubmission(w, r, submissionEntry, err)
+			return
+		}
+	}
+
+	photoFiles := r.MultipartForm.File["photo"]
+	if len(photoFiles) > maxEntryPhotos {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectTooLarge, "too many photos"))
+		return
+	}
+	photos := make([]*processedPhoto, 0, len(photoFiles))
+	for _, fh := range photoFiles {
+		data, err := readFileHeader(fh, "photo", maxPhotoBytes)
+		if err != nil {
+			s.rejectSubmission(w, r, submissionEntry, err)
+			return
+		}
+		photo, err := processPhoto(data)
+		if err != nil {
+			s.rejectPhoto(w, r, submissionEntry, err)
+			return
+		}
+		photos = append(photos, photo)
+	}
+
+	if text == "" && voice == nil && len(photos) == 0 {
+		s.rejectSubmission(w, r, submissionEntry, reject(rejectEmpty, "add a message, a voice note or a photo"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	editToken, editTokenHash, err := s.newEditToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "generate edit token", "err", err)
+		serverError(w, r, "failed to store entry")
+		return
+	}
+
+	// The entry holds the edit token; its items are changed through it.
+	var createdAt time.Time
+	entryID, err := s.insertWithEntry(ctx, guestName, invite, editTokenHash, func(tx pgx.Tx, entryID int) error {
+		if err := tx.QueryRow(ctx, `SELECT created_at FROM entries WHERE id = $1`, entryID).Scan(&createdAt); err != nil {
+			return err
+		}
+		if text != "" {
+			const insertMessage = `INSERT INTO messages (entry_id, guest_name, text, invite_id, invite_kind, table_label, invite_guest) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+			if _, err := tx.Exec(ctx, insertMessage, entryID, guestName, text, inviteID, inviteKind, tableLabel, inviteGuest); err != nil {
+				return err
+			}
+		}
+		var id int
+		var itemCreated time.Time
+		if voice != nil {
+			if err := tx.QueryRow(ctx, insertVoiceQuery, entryID, guestName, note, voice.audio, voice.mimeType, voice.durationSeconds,
+				inviteID, inviteKind, tableLabel, inviteGuest, "", voice.waveform).Scan(&id, &itemCreated); err != nil {
+				return err
+			}
+			if err := enqueueVoiceJobs(ctx, tx, id, s.transcribing); err != nil {
+				return err
+			}
+		}
+		for _, photo := range photos {
+			if err := tx.QueryRow(ctx, insertPhotoQuery, entryID, guestName, caption, photo.image, photo.mimeType, photo.width, photo.height, len(photo.image), photo.thumbnail,
+				inviteID, inviteKind, tableLabel, inviteGuest, "").Scan(&id, &itemCreated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "insert entry", "err", err)
+		serverError(w, r, "failed to store entry")
+		return
+	}
+	s.metrics.submitted.WithLabelValues(submissionEntry).Inc()
+
+	if voice != nil {
+		s.voiceStored()
+	}
+	writeJSON(w, http.StatusCreated, s.receipt(entryID, entryID, editToken, createdAt))
+}
+
+// handleEntryEdit lets the holder of an entry receipt change the name or
+// text (PATCH) or delete the whole entry (DELETE) at /entry/{id}.
+func (s *server) handleEntryEdit(w http.ResponseWriter, r *http.Request) {
+	setGuestEditCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, ok := guestEditID(r.URL.Path, "/entry/")
+	if !ok || (r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	var payload struct {
+		Name *string `json:"name"`
+		Text *string `json:"text"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid entry payload", http.StatusBadRequest)
+			return
+		}
+		if payload.Name == nil && payload.Text == nil {
+			clientError(w, r, "nothing to update", http.StatusBadRequest)
+			return
+		}
+		if payload.Name != nil {
+			name := strings.TrimSpace(*payload.Name)
+			if name == "" {
+				clientError(w, r, "name is required", http.StatusBadRequest)
+				return
+			}
+			if len([]rune(name)) > s.limits.MaxNameLength {
+				clientError(w, r, "name is too long", http.StatusBadRequest)
+				return
+			}
+			payload.Name = &name
+		}
+		if payload.Text != nil {
+			text := strings.TrimSpace(*payload.Text)
+			if len([]rune(text)) > s.limits.MaxMessageLength {
+				clientError(w, r, "message too long", http.StatusBadRequest)
+				return
+			}
+			payload.Text = &text
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := s.checkEditToken(ctx, "entries", id, r.Header.Get(editTokenHeaderName)); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := s.pool.Exec(ctx, `DELETE FROM entries WHERE id = $1`, id); err != nil {
+			slog.ErrorContext(r.Context(), "delete entry", "err", err)
+			serverError(w, r, "failed to delete entry")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if err := s.updateEntry(ctx, id, payload.Name, payload.Text); err != nil {
+		slog.ErrorContext(r.Context(), "update entry", "err", err)
+		serverError(w, r, "failed to update entry")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// updateEntry renames an entry and all its items, and sets, adds or removes
+// its text message. An empty text removes the message.
+func (s *server) updateEntry(ctx context.Context, id int, name, text *string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if name != nil {
+		if err := renameEntry(ctx, tx, id, *name); err != nil {
+			return err
+		}
+	}
+	if text != nil {
+		if *text == "" {
+			if _, err := tx.Exec(ctx, `DELETE FROM messages WHERE entry_id = $1`, id); err != nil {
+				return err
+			}
+		} else {
+			tag, err := tx.Exec(ctx, `UPDATE messages SET text = $2, edited_at = NOW() WHERE entry_id = $1`, id, *text)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				const addMessage = `
+INSERT INTO messages (entry_id, guest_name, text, invite_id, invite_kind, table_label, invite_guest)
+SELECT id, guest_name, $2, invite_id, invite_kind, table_label, invite_guest FROM entries WHERE id = $1`
+				if _, err := tx.Exec(ctx, addMessage, id, *text); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE entries SET edited_at = NOW() WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// renameEntry sets the guest name of an entry and every item in it, which
+// all show the same name.
+func renameEntry(ctx context.Context, tx pgx.Tx, id int, name string) error {
+	for _, table := range []string{"entries", "messages", "voice_messages", "photo_messages", "video_messages"} {
+		column := "entry_id"
+		if table == "entries" {
+			column = "id"
+		}
+		if _, err := tx.Exec(ctx, `UPDATE `+table+` SET guest_name = $2 WHERE `+column+` = $1`, id, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEntries returns the newest entries with their items attached.
+func (s *server) loadEntries(ctx context.Context, limit int) ([]entry, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, guest_name, invite_id, table_label, created_at FROM entries ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	var out []entry
+	byID := map[int]*entry{}
+	var ids []int
+	for rows.Next() {
+		e := entry{VoiceMessages: []voiceMessageMetadata{}, PhotoMessages: []photoMessageMetadata{}, VideoMessages: []videoMessageMetadata{}}
+		if err := rows.Scan(&e.ID, &e.GuestName, &e.InviteID, &e.TableLabel, &e.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, e)
+		ids = append(ids, e.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		byID[out[i].ID] = &out[i]
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	err = eachEntryItem(ctx, s, `SELECT `+messageColumns+` FROM messages WHERE entry_id = ANY($1) ORDER BY id`, ids, func(rows pgx.Rows) error {
+		m, err := scanMessage(rows)
+		if err == nil {
+			byID[m.EntryID].Message = &m
+		}
+		return err
+	})
+	if err == nil {
+		err = eachEntryItem(ctx, s, `SELECT `+voiceMessageColumns+` FROM voice_messages WHERE entry_id = ANY($1) ORDER BY id`, ids, func(rows pgx.Rows) error {
+			vm, err := scanVoiceMessage(rows)
+			if err == nil {
+				e := byID[vm.EntryID]
+				e.VoiceMessages = append(e.VoiceMessages, vm)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = eachEntryItem(ctx, s, `SELECT `+photoMessageColumns+` FROM photo_messages WHERE entry_id = ANY($1) ORDER BY id`, ids, func(rows pgx.Rows) error {
+			pm, err := scanPhotoMessage(rows)
+			if err == nil {
+				e := byID[pm.EntryID]
+				e.PhotoMessages = append(e.PhotoMessages, pm)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = eachEntryItem(ctx, s, `SELECT `+videoMessageColumns+` FROM video_messages WHERE entry_id = ANY($1) ORDER BY id`, ids, func(rows pgx.Rows) error {
+			vm, err := scanVideoMessage(rows)
+			if err == nil {
+				e := byID[vm.EntryID]
+				e.VideoMessages = append(e.VideoMessages, vm)
+			}
+			return err
+		})
+	}
+	return out, err
+}
+
+func eachEntryItem(ctx context.Context, s *server, query string, ids []int, fn func(pgx.Rows) error) error {
+	rows, err := s.pool.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// handleEntries lists entries with their items (GET /admin/entries).
+func (s *server) handleEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	entries, err := s.loadEntries(ctx, maxListLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query entries", "err", err)
+		serverError(w, r, "failed to fetch entries")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const exportTimeout = 10 * time.Minute
+
+// exportRow is one message or voice note in /admin/export. Voice notes put
+// their note in Text; the voice-only fields are empty for messages.
+type exportRow struct {
+	Type               string    `json:"type"`
+	ID                 int       `json:"id"`
+	EntryID            int       `json:"entry_id"`
+	GuestName          string    `json:"guest_name"`
+	Text               string    `json:"text"`
+	Approved           bool      `json:"approved"`
+	InviteID           string    `json:"invite_id"`
+	TableLabel         string    `json:"table_label"`
+	CreatedAt          time.Time `json:"created_at"`
+	DurationSeconds    int       `json:"duration_seconds,omitempty"`
+	MimeType           string    `json:"mime_type,omitempty"`
+	Transcript         string    `json:"transcript,omitempty"`
+	TranscriptLanguage string    `json:"transcript_language,omitempty"`
+}
+
+var exportCSVHeader = []string{"type", "id", "entry_id", "guest_name", "text", "approved", "invite_id", "table_label", "created_at", "duration_seconds", "mime_type", "transcript", "transcript_language"}
+
+func (e exportRow) csvRecord() []string {
+	duration := ""
+	if e.Type == "voice" {
+		duration = strconv.Itoa(e.DurationSeconds)
+	}
+	return []string{
+		e.Type, strconv.Itoa(e.ID), strconv.Itoa(e.EntryID), csvText(e.GuestName), csvText(e.Text), strconv.FormatBool(e.Approved),
+		e.InviteID, csvText(e.TableLabel), e.CreatedAt.UTC().Format(time.RFC3339), duration, e.MimeType, csvText(e.Transcript), e.TranscriptLanguage,
+	}
+}
+
+// exportQuery merges both tables into one time-ordered result. $1 and $2
+// bound created_at (NULL for open ends) and $3 picks a type, or both when
+// it is empty.
+const exportQuery = `
+SELECT * FROM (
+  SELECT 'message' AS type, id, COALESCE(entry_id, 0), guest_name, text, approved, invite_id, table_label, created_at,
+    0, '', '', ''
+  FROM messages
+  UNION ALL
+  SELECT 'voice', id, COALESCE(entry_id, 0), guest_name, COALESCE(note, ''), approved, invite_id, table_label, created_at,
+    duration_seconds, mime_type, transcript, transcript_language
+  FROM voice_messages
+) t
+WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+  AND ($2::timestamptz IS NULL OR created_at < $2)
+  AND ($3 = '' OR type = $3)
+ORDER BY created_at, type, id`
+
+// exportWriter writes rows in one of the export formats.
+type exportWriter interface {
+	write(row exportRow) error
+	close() error
+}
+
+// handleExport streams GET /admin/export?format=csv|ndjson|json with every
+// message and voice note (metadata only). from and to take a date or an
+// RFC 3339 time; a plain to date includes that whole day. type=message or
+// type=voice limits the export to one kind. Rows are written as pgx reads
+// them off the connection, so the result is never held in memory.
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	case "json":
+		contentType = "application/json"
+	default:
+		clientError(w, r, "format must be csv, ndjson or json", http.StatusBadRequest)
+		return
+	}
+	from, err := parseExportTime(q.Get("from"), false)
+	if err != nil {
+		clientError(w, r, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := parseExportTime(q.Get("to"), true)
+	if err != nil {
+		clientError(w, r, "invalid to", http.StatusBadRequest)
+		return
+	}
+	kind := q.Get("type")
+	if kind != "" && kind != "message" && kind != "voice" {
+		clientError(w, r, "type must be message or voice", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), exportTimeout)
+	defer cancel()
+	extendWriteDeadline(w, r, exportTimeout)
+	rows, err := s.pool.Query(ctx, exportQuery, from, to, kind)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query export", "err", err)
+		serverError(w, r, "failed to export")
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="guestbook-%s.%s"`, time.Now().Format("20060102"), format))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := copyExport(rows, newExportWriter(w, format)); err != nil {
+		// The status is already sent; the client sees a truncated file.
+		slog.ErrorContext(r.Context(), "write export", "err", err)
+	}
+}
+
+// copyExport writes every row of an exportQuery result to out.
+func copyExport(rows pgx.Rows, out exportWriter) error {
+	for rows.Next() {
+		var e exportRow
+		if err := rows.Scan(&e.Type, &e.ID, &e.EntryID, &e.GuestName, &e.Text, &e.Approved, &e.InviteID, &e.TableLabel, &e.CreatedAt,
+			&e.DurationSeconds, &e.MimeType, &e.Transcript, &e.TranscriptLanguage); err != nil {
+			return err
+		}
+		if err := out.write(e); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// runExport implements `export [-format f] [-from t] [-to t] [-type t]
+// [-o file]`, the command-line side of /admin/export. -format zip writes
+// the /admin/archive.zip archive instead and ignores the filters.
+func runExport(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	opts := configFlags(flags)
+	format := flags.String("format", "csv", "csv, ndjson, json or zip")
+	fromRaw := flags.String("from", "", "only rows created at or after this date or RFC 3339 time")
+	toRaw := flags.String("to", "", "only rows created before this time, or on or before this date")
+	kind := flags.String("type", "", "message or voice (default: both)")
+	outPath := flags.String("o", "-", "file to write, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	switch *format {
+	case "csv", "ndjson", "json", "zip":
+	default:
+		return errors.New("-format must be csv, ndjson, json or zip")
+	}
+	from, err := parseExportTime(*fromRaw, false)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	to, err := parseExportTime(*toRaw, true)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if *kind != "" && *kind != "message" && *kind != "voice" {
+		return errors.New("-type must be message or voice")
+	}
+
+	pool, err := openDB(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	out := stdout
+	var file *os.File
+	if *outPath != "-" {
+		if file, err = os.Create(*outPath); err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if *format == "zip" {
+		tx, messages, voices, err := openArchive(ctx, pool)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(context.WithoutCancel(ctx))
+		zw := zip.NewWriter(out)
+		if err := writeArchive(ctx, tx, zw, messages, voices); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	} else {
+		rows, err := pool.Query(ctx, exportQuery, from, to, *kind)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		if err := copyExport(rows, newExportWriter(out, *format)); err != nil {
+			return err
+		}
+	}
+	if file != nil {
+		return file.Close()
+	}
+	return nil
+}
+
+// parseExportTime reads an RFC 3339 time or a YYYY-MM-DD date (midnight
+// UTC). With endOfDay a date means the end of that day. Empty is nil.
+func parseExportTime(raw string, endOfDay bool) (*time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func newExportWriter(w io.Writer, format string) exportWriter {
+	switch format {
+	case "ndjson":
+		return &ndjsonExport{enc: json.NewEncoder(w)}
+	case "json":
+		return &jsonExport{w: w}
+	default:
+		return &csvExport{w: csv.NewWriter(w)}
+	}
+}
+
+type csvExport struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvExport) write(row exportRow) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(exportCSVHeader); err != nil {
+			return err
+		}
+	}
+	return c.w.Write(row.csvRecord())
+}
+
+func (c *csvExport) close() error {
+	if !c.header {
+		c.header = true
+		c.w.Write(exportCSVHeader)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonExport struct{ enc *json.Encoder }
+
+func (n *ndjsonExport) write(row exportRow) error { return n.enc.Encode(row) }
+func (n *ndjsonExport) close() error              { return nil }
+
+// jsonExport writes one array, one element at a time.
+type jsonExport struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonExport) write(row exportRow) error {
+	raw, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(raw)
+	return err
+}
+
+func (j *jsonExport) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportCSVRecordEscapesFormulas(t *testing.T) {
+	row := exportRow{
+		Type:       "voice",
+		ID:         7,
+		GuestName:  "@Anna",
+		Text:       "=1+1",
+		CreatedAt:  time.Date(2026, 6, 14, 18, 0, 0, 0, time.UTC),
+		Transcript: "+49 170 1234567 call me",
+	}
+	rec := row.csvRecord()
+	if len(rec) != len(exportCSVHeader) {
+		t.Fatalf("record has %d fields, header has %d", len(rec), len(exportCSVHeader))
+	}
+	for i, want := range map[int]string{3: "'@Anna", 4: "'=1+1", 11: "'+49 170 1234567 call me"} {
+		if rec[i] != want {
+			t.Errorf("%s = %q, want %q", exportCSVHeader[i], rec[i], want)
+		}
+	}
+}
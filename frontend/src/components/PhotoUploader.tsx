@@ -0,0 +1,181 @@
+import { useEffect, useState } from 'react'
+import { deletePhotoMessage, receiptStillEditable, submitPhotoMessage, type Receipt } from '../lib/api'
+
+type UploadState = 'idle' | 'uploading' | 'success' | 'error'
+
+type PhotoUploaderProps = {
+  defaultName?: string
+  onNameChange?: (name: string) => void
+}
+
+export const MAX_PHOTO_BYTES = 10 * 1024 * 1024
+
+// The server only accepts JPEG, PNG and WebP. iPhones hand over HEIC, which
+// Safari can still decode, so re-encode it as JPEG in the browser first.
+export async function toUploadable(file: File): Promise<Blob> {
+  const isHeic = /image\/hei[cf]/.test(file.type) || /\.hei[cf]$/i.test(file.name)
+  if (!isHeic) return file
+  const bitmap = await createImageBitmap(file)
+  const canvas = document.createElement('canvas')
+  canvas.width = bitmap.width
+  canvas.height = bitmap.height
+  canvas.getContext('2d')?.drawImage(bitmap, 0, 0)
+  bitmap.close()
+  return new Promise((resolve, reject) => {
+    canvas.toBlob(
+      (blob) => (blob ? resolve(blob) : reject(new Error('Could not convert this photo.'))),
+      'image/jpeg',
+      0.9,
+    )
+  })
+}
+
+export default function PhotoUploader({ defaultName = '', onNameChange }: PhotoUploaderProps) {
+  const [state, setState] = useState<UploadState>('idle')
+  const [name, setName] = useState(defaultName)
+  const [caption, setCaption] = useState('')
+  const [photo, setPhoto] = useState<Blob | null>(null)
+  const [previewUrl, setPreviewUrl] = useState<string | null>(null)
+  const [error, setError] = useState('')
+  const [receipt, setReceipt] = useState<Receipt | null>(null)
+
+  useEffect(() => {
+    setName((prev) => (prev ? prev : defaultName))
+  }, [defaultName])
+
+  useEffect(() => {
+    return () => {
+      if (previewUrl) URL.revokeObjectURL(previewUrl)
+    }
+  }, [previewUrl])
+
+  const handleFile = async (file: File | undefined) => {
+    if (!file) return
+    setError('')
+    try {
+      const blob = await toUploadable(file)
+      if (blob.size > MAX_PHOTO_BYTES) {
+        throw new Error('That photo is larger than 10 MB.')
+      }
+      setPhoto(blob)
+      setPreviewUrl(URL.createObjectURL(blob))
+      setState('idle')
+    } catch (err) {
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Could not read this photo.')
+    }
+  }
+
+  const reset = () => {
+    setPhoto(null)
+    setPreviewUrl(null)
+    setCaption('')
+  }
+
+  const handleUpload = async () => {
+    if (!photo) return
+    if (!name.trim()) {
+      setState('error')
+      setError('Please add your name so we know who sent it.')
+      return
+    }
+    try {
+      setState('uploading')
+      setError('')
+      setReceipt(await submitPhotoMessage(photo, caption, name.trim()))
+      reset()
+      setState('success')
+    } catch (err) {
+      console.error(err)
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Failed to upload photo.')
+    }
+  }
+
+  const handleDelete = async () => {
+    if (!receiptStillEditable(receipt)) return
+    try {
+      await deletePhotoMessage(receipt)
+      setReceipt(null)
+      setState('idle')
+    } catch (err) {
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Failed to remove photo.')
+    }
+  }
+
+  return (
+    <div className="voice-card">
+      <div className="voice-header">
+        <p className="voice-title">Photo</p>
+        <p className="voice-subtitle">JPEG, PNG or WebP · up to 10 MB</p>
+      </div>
+
+      {!photo && (
+        <label className="photo-picker">
+          <input
+            type="file"
+            accept="image/jpeg,image/png,image/webp,image/heic,image/heif"
+            onChange={(event) => {
+              void handleFile(event.target.files?.[0])
+              event.target.value = ''
+            }}
+          />
+          Choose or take a photo
+        </label>
+      )}
+
+      {photo && previewUrl && (
+        <div className="voice-preview">
+          <img className="photo-preview" src={previewUrl} alt="Selected photo preview" />
+          <label htmlFor="photo-name">Your name</label>
+          <input
+            id="photo-name"
+            type="text"
+            maxLength={80}
+            placeholder="Jane & John"
+            value={name}
+            onChange={(event) => {
+              setName(event.target.value)
+              onNameChange?.(event.target.value)
+            }}
+            disabled={state === 'uploading'}
+            required
+          />
+          <label htmlFor="photo-caption">Caption (optional)</label>
+          <input
+            id="photo-caption"
+            type="text"
+            maxLength={120}
+            placeholder="Add a short caption"
+            value={caption}
+            onChange={(event) => setCaption(event.target.value)}
+          />
+          <div className="voice-actions">
+            <button type="button" className="ghost-button" onClick={reset}>
+              Pick another
+            </button>
+            <button type="button" onClick={handleUpload} disabled={state === 'uploading'}>
+              {state === 'uploading' ? 'Uploading…' : 'Send photo'}
+            </button>
+          </div>
+        </div>
+      )}
+
+      {state === 'success' && (
+        <div className="message-success">
+          Photo saved!
+          {receiptStillEditable(receipt) && (
+            <>
+              {' '}
+              <button type="button" className="link-button" onClick={handleDelete}>
+                Delete it
+              </button>
+            </>
+          )}
+        </div>
+      )}
+      {state === 'error' && error && <div className="message-error">Error: {error}</div>}
+    </div>
+  )
+}
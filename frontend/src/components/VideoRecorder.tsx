@@ -0,0 +1,295 @@
+import { useEffect, useRef, useState } from 'react'
+import {
+  DEFAULT_LIMITS,
+  deleteVideoMessage,
+  receiptStillEditable,
+  submitVideoMessage,
+  type Receipt,
+  type Limits,
+} from '../lib/api'
+
+const PREFERRED_MIME_TYPES = ['video/webm;codecs=vp9,opus', 'video/webm;codecs=vp8,opus', 'video/webm', 'video/mp4']
+
+type RecorderState = 'idle' | 'recording' | 'preview' | 'uploading' | 'success' | 'error'
+
+type VideoRecorderProps = {
+  defaultName?: string
+  onNameChange?: (name: string) => void
+  limits?: Limits
+}
+
+export default function VideoRecorder({ defaultName = '', onNameChange, limits = DEFAULT_LIMITS }: VideoRecorderProps) {
+  const maxDuration = limits.maxVideoDurationSeconds
+  const [state, setState] = useState<RecorderState>('idle')
+  const [name, setName] = useState(defaultName)
+  const [error, setError] = useState('')
+  const [duration, setDuration] = useState(0)
+  const [note, setNote] = useState('')
+  const [videoBlob, setVideoBlob] = useState<Blob | null>(null)
+  const [previewUrl, setPreviewUrl] = useState<string | null>(null)
+  const [isSupported, setIsSupported] = useState(true)
+  const [receipt, setReceipt] = useState<Receipt | null>(null)
+
+  const liveRef = useRef<HTMLVideoElement | null>(null)
+  const mediaRecorderRef = useRef<MediaRecorder | null>(null)
+  const chunksRef = useRef<BlobPart[]>([])
+  const streamRef = useRef<MediaStream | null>(null)
+  const intervalRef = useRef<number | null>(null)
+  const timeoutRef = useRef<number | null>(null)
+  const startRef = useRef<number>(0)
+
+  useEffect(() => {
+    if (!navigator.mediaDevices || typeof window.MediaRecorder === 'undefined') {
+      setIsSupported(false)
+    }
+  }, [])
+
+  useEffect(() => {
+    setName((prev) => (prev ? prev : defaultName))
+  }, [defaultName])
+
+  useEffect(() => {
+    return () => {
+      cleanupRecording()
+      if (previewUrl) {
+        URL.revokeObjectURL(previewUrl)
+      }
+    }
+  }, [previewUrl])
+
+  const clearTimers = () => {
+    if (intervalRef.current !== null) {
+      window.clearInterval(intervalRef.current)
+    }
+    if (timeoutRef.current !== null) {
+      window.clearTimeout(timeoutRef.current)
+    }
+    intervalRef.current = null
+    timeoutRef.current = null
+  }
+
+  const stopStream = () => {
+    if (streamRef.current) {
+      streamRef.current.getTracks().forEach((track) => track.stop())
+      streamRef.current = null
+    }
+    if (liveRef.current) {
+      liveRef.current.srcObject = null
+    }
+  }
+
+  const cleanupRecording = () => {
+    clearTimers()
+    if (mediaRecorderRef.current && mediaRecorderRef.current.state === 'recording') {
+      mediaRecorderRef.current.stop()
+    }
+    mediaRecorderRef.current = null
+    stopStream()
+    chunksRef.current = []
+  }
+
+  const pickSupportedMimeType = (): string | undefined => {
+    if (!window.MediaRecorder || !MediaRecorder.isTypeSupported) return undefined
+    return PREFERRED_MIME_TYPES.find((type) => MediaRecorder.isTypeSupported(type))
+  }
+
+  const startRecording = async () => {
+    if (!isSupported) return
+    try {
+      if (videoBlob || previewUrl) {
+        resetRecording()
+      }
+      setError('')
+      setDuration(0)
+      const stream = await navigator.mediaDevices.getUserMedia({
+        audio: true,
+        video: { facingMode: 'user', width: { ideal: 1280 }, height: { ideal: 720 } },
+      })
+      streamRef.current = stream
+      setState('recording')
+      if (liveRef.current) {
+        liveRef.current.srcObject = stream
+        void liveRef.current.play().catch(() => {
+          /* autoplay may be blocked; the preview is optional */
+        })
+      }
+      const mimeType = pickSupportedMimeType()
+      // A modest bitrate keeps a 30 second clip well under the upload limit.
+      const options: MediaRecorderOptions = { videoBitsPerSecond: 2_500_000 }
+      if (mimeType) options.mimeType = mimeType
+      const recorder = new MediaRecorder(stream, options)
+      mediaRecorderRef.current = recorder
+      chunksRef.current = []
+
+      recorder.ondataavailable = (event) => {
+        if (event.data.size > 0) chunksRef.current.push(event.data)
+      }
+      recorder.onstop = () => {
+        clearTimers()
+        const blob = new Blob(chunksRef.current, { type: recorder.mimeType || mimeType || 'video/webm' })
+        setVideoBlob(blob)
+        setPreviewUrl(URL.createObjectURL(blob))
+        chunksRef.current = []
+        stopStream()
+        setState('preview')
+      }
+
+      startRef.current = Date.now()
+      recorder.start()
+
+      intervalRef.current = window.setInterval(() => {
+        setDuration(Math.min(maxDuration, Math.floor((Date.now() - startRef.current) / 1000)))
+      }, 200)
+      timeoutRef.current = window.setTimeout(() => {
+        if (recorder.state === 'recording') {
+          stopRecording()
+        }
+      }, maxDuration * 1000)
+    } catch (err) {
+      console.error(err)
+      stopStream()
+      setError('Unable to access the camera. Check browser permissions.')
+      setState('error')
+    }
+  }
+
+  const stopRecording = () => {
+    if (mediaRecorderRef.current && mediaRecorderRef.current.state === 'recording') {
+      mediaRecorderRef.current.stop()
+    }
+  }
+
+  const resetRecording = () => {
+    cleanupRecording()
+    setVideoBlob(null)
+    if (previewUrl) {
+      URL.revokeObjectURL(previewUrl)
+      setPreviewUrl(null)
+    }
+    setDuration(0)
+    setNote('')
+    setState('idle')
+    setError('')
+  }
+
+  const handleUpload = async () => {
+    if (!name.trim()) {
+      setError('Please enter your name before sending.')
+      setState('error')
+      return
+    }
+    if (!videoBlob) return
+    try {
+      setState('uploading')
+      setError('')
+      setReceipt(await submitVideoMessage(videoBlob, note, name.trim()))
+      if (previewUrl) {
+        URL.revokeObjectURL(previewUrl)
+      }
+      setVideoBlob(null)
+      setPreviewUrl(null)
+      setDuration(0)
+      setNote('')
+      setState('success')
+      setName(name.trim())
+      onNameChange?.(name.trim())
+    } catch (err) {
+      console.error(err)
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Failed to upload video message.')
+    }
+  }
+
+  const handleDelete = async () => {
+    if (!receiptStillEditable(receipt)) return
+    try {
+      await deleteVideoMessage(receipt)
+      setReceipt(null)
+      setState('idle')
+    } catch (err) {
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Failed to remove video.')
+    }
+  }
+
+  if (!isSupported) {
+    return <div className="voice-card">Video recording isn’t supported in this browser.</div>
+  }
+
+  return (
+    <div className="voice-card">
+      <div className="voice-header">
+        <p className="voice-title">Video message</p>
+        <p className="voice-subtitle">Up to {maxDuration} seconds</p>
+      </div>
+
+      <video ref={liveRef} className="video-live" muted playsInline hidden={state !== 'recording'} />
+
+      <div className="voice-controls">
+        <p className="timer-display">{new Date(duration * 1000).toISOString().substring(14, 19)}</p>
+        {state !== 'recording' && (
+          <button type="button" className="record-btn" onClick={startRecording}>
+            Start recording
+          </button>
+        )}
+        {state === 'recording' && (
+          <button type="button" className="stop-btn" onClick={stopRecording}>
+            Stop
+          </button>
+        )}
+      </div>
+
+      {videoBlob && previewUrl && (
+        <div className="voice-preview">
+          <video className="video-preview" controls playsInline src={previewUrl}></video>
+          <label htmlFor="video-name">Your name</label>
+          <input
+            id="video-name"
+            type="text"
+            maxLength={limits.maxNameLength}
+            placeholder="Jane & John"
+            value={name}
+            onChange={(event) => {
+              setName(event.target.value)
+              onNameChange?.(event.target.value)
+            }}
+            disabled={state === 'uploading'}
+            required
+          />
+          <label htmlFor="video-note">Caption (optional)</label>
+          <input
+            id="video-note"
+            type="text"
+            maxLength={120}
+            placeholder="Add a short caption"
+            value={note}
+            onChange={(event) => setNote(event.target.value)}
+          />
+          <div className="voice-actions">
+            <button type="button" className="ghost-button" onClick={resetRecording}>
+              Start over
+            </button>
+            <button type="button" onClick={handleUpload} disabled={state === 'uploading'}>
+              {state === 'uploading' ? 'Uploading…' : 'Send video'}
+            </button>
+          </div>
+        </div>
+      )}
+
+      {state === 'success' && (
+        <div className="message-success">
+          Video saved!
+          {receiptStillEditable(receipt) && (
+            <>
+              {' '}
+              <button type="button" className="link-button" onClick={handleDelete}>
+                Delete it
+              </button>
+            </>
+          )}
+        </div>
+      )}
+      {state === 'error' && error && <div className="message-error">Error: {error}</div>}
+    </div>
+  )
+}
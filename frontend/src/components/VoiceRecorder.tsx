@@ -1,7 +1,13 @@
 import { useEffect, useRef, useState } from 'react'
-import { submitVoiceMessage } from '../lib/api'
+import {
+  DEFAULT_LIMITS,
+  deleteVoiceMessage,
+  receiptStillEditable,
+  submitVoiceMessage,
+  type Receipt,
+  type Limits,
+} from '../lib/api'
 
-const MAX_DURATION = 60
 const PREFERRED_MIME_TYPES = ['audio/webm;codecs=opus', 'audio/webm', 'audio/mp4']
 
 type RecorderState = 'idle' | 'recording' | 'preview' | 'uploading' | 'success' | 'error'
@@ -9,9 +15,11 @@ type RecorderState = 'idle' | 'recording' | 'preview' | 'uploading' | 'success'
 type VoiceRecorderProps = {
   defaultName?: string
   onNameChange?: (name: string) => void
+  limits?: Limits
 }
 
-export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceRecorderProps) {
+export default function VoiceRecorder({ defaultName = '', onNameChange, limits = DEFAULT_LIMITS }: VoiceRecorderProps) {
+  const maxDuration = limits.maxAudioDurationSeconds
   const [state, setState] = useState<RecorderState>('idle')
   const [name, setName] = useState(defaultName)
   const [error, setError] = useState('')
@@ -20,6 +28,7 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
   const [audioBlob, setAudioBlob] = useState<Blob | null>(null)
   const [previewUrl, setPreviewUrl] = useState<string | null>(null)
   const [isSupported, setIsSupported] = useState(true)
+  const [receipt, setReceipt] = useState<Receipt | null>(null)
 
   const mediaRecorderRef = useRef<MediaRecorder | null>(null)
   const chunksRef = useRef<BlobPart[]>([])
@@ -119,14 +128,14 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
       recorder.start()
 
       intervalRef.current = window.setInterval(() => {
-        const elapsed = Math.min(MAX_DURATION, Math.floor((Date.now() - startRef.current) / 1000))
+        const elapsed = Math.min(maxDuration, Math.floor((Date.now() - startRef.current) / 1000))
         setDuration(elapsed)
       }, 200)
       timeoutRef.current = window.setTimeout(() => {
         if (recorder.state === 'recording') {
           stopRecording()
         }
-      }, MAX_DURATION * 1000)
+      }, maxDuration * 1000)
     } catch (err) {
       console.error(err)
       setError('Unable to access microphone. Check browser permissions.')
@@ -138,7 +147,7 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
     if (mediaRecorderRef.current && mediaRecorderRef.current.state === 'recording') {
       mediaRecorderRef.current.stop()
       const elapsed = Math.max(1, Math.round((Date.now() - startRef.current) / 1000))
-      setDuration(Math.min(MAX_DURATION, elapsed))
+      setDuration(Math.min(maxDuration, elapsed))
     }
   }
 
@@ -165,7 +174,7 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
     try {
       setState('uploading')
       setError('')
-      await submitVoiceMessage(audioBlob, duration, note, name.trim())
+      setReceipt(await submitVoiceMessage(audioBlob, duration, note, name.trim()))
       if (previewUrl) {
         URL.revokeObjectURL(previewUrl)
       }
@@ -183,6 +192,18 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
     }
   }
 
+  const handleDelete = async () => {
+    if (!receiptStillEditable(receipt)) return
+    try {
+      await deleteVoiceMessage(receipt)
+      setReceipt(null)
+      setState('idle')
+    } catch (err) {
+      setState('error')
+      setError(err instanceof Error ? err.message : 'Failed to remove voice note.')
+    }
+  }
+
   if (!isSupported) {
     return <div className="voice-card">Voice recording isn’t supported in this browser.</div>
   }
@@ -196,7 +217,7 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
     <div className="voice-card">
       <div className="voice-header">
         <p className="voice-title">Voice note</p>
-        <p className="voice-subtitle">Up to 60 seconds</p>
+        <p className="voice-subtitle">Up to {maxDuration} seconds</p>
       </div>
 
       <div className="voice-controls">
@@ -219,7 +240,7 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
           <input
             id="voice-name"
             type="text"
-            maxLength={80}
+            maxLength={limits.maxNameLength}
             placeholder="Jane & John"
             value={name}
             onChange={(event) => {
@@ -250,7 +271,19 @@ export default function VoiceRecorder({ defaultName = '', onNameChange }: VoiceR
         </div>
       )}
 
-      {state === 'success' && <div className="message-success">Voice note saved!</div>}
+      {state === 'success' && (
+        <div className="message-success">
+          Voice note saved!
+          {receiptStillEditable(receipt) && (
+            <>
+              {' '}
+              <button type="button" className="link-button" onClick={handleDelete}>
+                Delete it
+              </button>
+            </>
+          )}
+        </div>
+      )}
       {state === 'error' && error && <div className="message-error">Error: {error}</div>}
     </div>
   )
@@ -32,6 +32,27 @@ export type VoiceMessage = {
   audioUrl: string
 }
 
+const INVITE_STORAGE_KEY = 'guestbook-invite'
+
+// The invite token arrives as ?invite=… on the QR link. Keep it so a guest
+// who reloads or navigates away can still post from the same table.
+export function currentInvite(): string {
+  const fromUrl = new URLSearchParams(window.location.search).get('invite')
+  if (fromUrl) {
+    try {
+      window.localStorage.setItem(INVITE_STORAGE_KEY, fromUrl)
+    } catch {
+      /* storage may be unavailable in private mode */
+    }
+    return fromUrl
+  }
+  try {
+    return window.localStorage.getItem(INVITE_STORAGE_KEY) ?? ''
+  } catch {
+    return ''
+  }
+}
+
 function withApiBase(path: string) {
   if (!API_BASE) return path
   return `${API_BASE}${path}`
@@ -43,16 +64,124 @@ async function handleResponse(response: Response) {
   throw new Error(text || 'Request failed')
 }
 
-export async function submitMessage(name: string, text: string) {
+// A receipt lets the guest fix or retract their own entry for a short while
+// after sending it.
+export type Receipt = {
+  id: number
+  // Where the item lives, e.g. /message or /entry; edits go to `${path}/${id}`.
+  path: string
+  editToken: string
+  editableUntil: string
+}
+
+type ApiReceipt = {
+  status: string
+  id: number
+  edit_token?: string
+  editable_until?: string
+}
+
+async function readReceipt(response: Response, path: string): Promise<Receipt | null> {
+  const payload = (await response.json().catch(() => null)) as ApiReceipt | null
+  if (!payload || !payload.edit_token || !payload.editable_until) return null
+  return { id: payload.id, path, editToken: payload.edit_token, editableUntil: payload.editable_until }
+}
+
+export function receiptStillEditable(receipt: Receipt | null): receipt is Receipt {
+  return receipt !== null && new Date(receipt.editableUntil).getTime() > Date.now()
+}
+
+export async function updateMessage(receipt: Receipt, text: string) {
+  const response = await fetch(withApiBase(`${receipt.path}/${receipt.id}`), {
+    method: 'PATCH',
+    headers: {
+      'Content-Type': 'application/json',
+      'X-Edit-Token': receipt.editToken,
+    },
+    body: JSON.stringify({ text }),
+  })
+  await handleResponse(response)
+}
+
+export async function deleteMessage(receipt: Receipt) {
+  const response = await fetch(withApiBase(`${receipt.path}/${receipt.id}`), {
+    method: 'DELETE',
+    headers: { 'X-Edit-Token': receipt.editToken },
+  })
+  await handleResponse(response)
+}
+
+export async function deleteVoiceMessage(receipt: Receipt) {
+  const response = await fetch(withApiBase(`/voice-message/${receipt.id}`), {
+    method: 'DELETE',
+    headers: { 'X-Edit-Token': receipt.editToken },
+  })
+  await handleResponse(response)
+}
+
+export type Limits = {
+  maxMessageLength: number
+  maxNameLength: number
+  maxAudioDurationSeconds: number
+  maxVideoDurationSeconds: number
+}
+
+// Used until /limits answers, and if it cannot be reached.
+export const DEFAULT_LIMITS: Limits = {
+  maxMessageLength: 500,
+  maxNameLength: 80,
+  maxAudioDurationSeconds: 60,
+  maxVideoDurationSeconds: 30,
+}
+
+// The server's limits for this guest's invite; events can have their own.
+export async function fetchLimits(): Promise<Limits> {
+  const invite = currentInvite()
+  const query = invite ? `?invite=${encodeURIComponent(invite)}` : ''
+  try {
+    const res = await fetch(withApiBase(`/limits${query}`))
+    if (!res.ok) return DEFAULT_LIMITS
+    const data = await res.json()
+    return {
+      maxMessageLength: data.max_message_length,
+      maxNameLength: data.max_name_length,
+      maxAudioDurationSeconds: data.max_audio_duration_seconds,
+      maxVideoDurationSeconds: data.max_video_duration_seconds,
+    }
+  } catch {
+    return DEFAULT_LIMITS
+  }
+}
+
+export async function submitMessage(name: string, text: string): Promise<Receipt | null> {
   const response = await fetch(withApiBase('/message'), {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
       Accept: 'application/json',
     },
-    body: JSON.stringify({ name, text }),
+    body: JSON.stringify({ name, text, invite: currentInvite() }),
+  })
+  await handleResponse(response)
+  return readReceipt(response, '/message')
+}
+
+// submitEntry sends a text message together with photos as one entry.
+export async function submitEntry(name: string, text: string, photos: Blob[]): Promise<Receipt | null> {
+  const form = new FormData()
+  form.append('name', name)
+  form.append('text', text)
+  photos.forEach((photo, index) => form.append('photo', photo, `photo-${index + 1}.jpg`))
+  const invite = currentInvite()
+  if (invite) {
+    form.append('invite', invite)
+  }
+  const response = await fetch(withApiBase('/entry'), {
+    method: 'POST',
+    body: form,
   })
   await handleResponse(response)
+  return readReceipt(response, '/entry')
 }
 
 export async function submitVoiceMessage(
@@ -60,11 +189,15 @@ export async function submitVoiceMessage(
   durationSeconds: number,
   note: string,
   name: string,
-) {
+): Promise<Receipt | null> {
   const form = new FormData()
   form.append('duration', String(durationSeconds))
   form.append('audio', blob, 'voice-message.webm')
   form.append('name', name)
+  const invite = currentInvite()
+  if (invite) {
+    form.append('invite', invite)
+  }
   if (note.trim()) {
     form.append('note', note.trim())
   }
@@ -73,6 +206,66 @@ export async function submitVoiceMessage(
     body: form,
   })
   await handleResponse(response)
+  return readReceipt(response, '/voice-message')
+}
+
+export async function submitPhotoMessage(
+  photo: Blob,
+  caption: string,
+  name: string,
+): Promise<Receipt | null> {
+  const form = new FormData()
+  form.append('photo', photo, 'photo.jpg')
+  form.append('name', name)
+  const invite = currentInvite()
+  if (invite) {
+    form.append('invite', invite)
+  }
+  if (caption.trim()) {
+    form.append('caption', caption.trim())
+  }
+  const response = await fetch(withApiBase('/photo-message'), {
+    method: 'POST',
+    body: form,
+  })
+  await handleResponse(response)
+  return readReceipt(response, '/photo-message')
+}
+
+export async function deletePhotoMessage(receipt: Receipt) {
+  const response = await fetch(withApiBase(`/photo-message/${receipt.id}`), {
+    method: 'DELETE',
+    headers: { 'X-Edit-Token': receipt.editToken },
+  })
+  await handleResponse(response)
+}
+
+export async function submitVideoMessage(video: Blob, note: string, name: string): Promise<Receipt | null> {
+  const form = new FormData()
+  const extension = video.type.includes('mp4') ? 'mp4' : 'webm'
+  form.append('video', video, `video-message.${extension}`)
+  form.append('name', name)
+  const invite = currentInvite()
+  if (invite) {
+    form.append('invite', invite)
+  }
+  if (note.trim()) {
+    form.append('note', note.trim())
+  }
+  const response = await fetch(withApiBase('/video-message'), {
+    method: 'POST',
+    body: form,
+  })
+  await handleResponse(response)
+  return readReceipt(response, '/video-message')
+}
+
+export async function deleteVideoMessage(receipt: Receipt) {
+  const response = await fetch(withApiBase(`/video-message/${receipt.id}`), {
+    method: 'DELETE',
+    headers: { 'X-Edit-Token': receipt.editToken },
+  })
+  await handleResponse(response)
 }
 
 export async function listMessages(): Promise<Message[]> {
@@ -1,16 +1,52 @@
-import { type FormEvent, useState } from 'react'
+import { type FormEvent, useEffect, useState } from 'react'
+import PhotoUploader, { MAX_PHOTO_BYTES, toUploadable } from '../components/PhotoUploader'
+import VideoRecorder from '../components/VideoRecorder'
 import VoiceRecorder from '../components/VoiceRecorder'
-import { submitMessage } from '../lib/api'
+import {
+  DEFAULT_LIMITS,
+  deleteMessage,
+  fetchLimits,
+  receiptStillEditable,
+  submitEntry,
+  submitMessage,
+  updateMessage,
+  type Receipt,
+} from '../lib/api'
 
-const MAX_LENGTH = 500
+const MAX_ATTACHED_PHOTOS = 4
 
 export default function GuestPage() {
   const [name, setName] = useState('')
   const [text, setText] = useState('')
   const [status, setStatus] = useState<'idle' | 'loading' | 'success' | 'error'>('idle')
   const [error, setError] = useState('')
+  const [receipt, setReceipt] = useState<Receipt | null>(null)
+  const [lastText, setLastText] = useState('')
+  const [editing, setEditing] = useState(false)
+  const [removed, setRemoved] = useState(false)
+  const [photos, setPhotos] = useState<Blob[]>([])
+  const [limits, setLimits] = useState(DEFAULT_LIMITS)
 
-  const remaining = MAX_LENGTH - text.length
+  useEffect(() => {
+    fetchLimits().then(setLimits)
+  }, [])
+
+  const remaining = limits.maxMessageLength - text.length
+
+  const handlePhotos = async (files: FileList | null) => {
+    if (!files) return
+    try {
+      setError('')
+      const picked = await Promise.all(Array.from(files).slice(0, MAX_ATTACHED_PHOTOS).map(toUploadable))
+      if (picked.some((photo) => photo.size > MAX_PHOTO_BYTES)) {
+        throw new Error('Each photo must be smaller than 10 MB.')
+      }
+      setPhotos(picked)
+    } catch (err) {
+      setStatus('error')
+      setError(err instanceof Error ? err.message : 'Could not read those photos.')
+    }
+  }
 
   const handleSubmit = async (event: FormEvent<HTMLFormElement>) => {
     event.preventDefault()
@@ -28,8 +64,18 @@ export default function GuestPage() {
     try {
       setStatus('loading')
       setError('')
-      await submitMessage(name.trim(), text.trim())
+      if (editing && receiptStillEditable(receipt)) {
+        await updateMessage(receipt, text.trim())
+      } else if (photos.length > 0) {
+        setReceipt(await submitEntry(name.trim(), text.trim(), photos))
+      } else {
+        setReceipt(await submitMessage(name.trim(), text.trim()))
+      }
+      setLastText(text.trim())
+      setEditing(false)
+      setRemoved(false)
       setText('')
+      setPhotos([])
       setName(name.trim())
       setStatus('success')
     } catch (err) {
@@ -38,6 +84,27 @@ export default function GuestPage() {
     }
   }
 
+  const startEditing = () => {
+    setEditing(true)
+    setText(lastText)
+    setStatus('idle')
+  }
+
+  const handleDelete = async () => {
+    if (!receiptStillEditable(receipt)) return
+    try {
+      setStatus('loading')
+      await deleteMessage(receipt)
+      setReceipt(null)
+      setEditing(false)
+      setRemoved(true)
+      setStatus('idle')
+    } catch (err) {
+      setStatus('error')
+      setError(err instanceof Error ? err.message : 'Failed to remove message.')
+    }
+  }
+
   return (
     <div className="guest-grid">
       <section className="panel">
@@ -51,7 +118,7 @@ export default function GuestPage() {
             id="guest-name"
             name="name"
             type="text"
-            maxLength={80}
+            maxLength={limits.maxNameLength}
             value={name}
             onChange={(event) => setName(event.target.value)}
             placeholder="Jane & John"
@@ -63,29 +130,74 @@ export default function GuestPage() {
             id="guest-message"
             name="text"
             rows={5}
-            maxLength={MAX_LENGTH}
+            maxLength={limits.maxMessageLength}
             value={text}
             onChange={(event) => setText(event.target.value)}
             placeholder="Share your wish, tip, or favorite memory..."
             disabled={status === 'loading'}
             required
           />
+          {!editing && (
+            <>
+              <label htmlFor="guest-photos">Attach photos (optional, up to {MAX_ATTACHED_PHOTOS})</label>
+              <input
+                id="guest-photos"
+                type="file"
+                accept="image/jpeg,image/png,image/webp,image/heic,image/heif"
+                multiple
+                onChange={(event) => handlePhotos(event.target.files)}
+                disabled={status === 'loading'}
+              />
+              {photos.length > 0 && (
+                <span className="panel-subtitle">
+                  {photos.length} photo{photos.length === 1 ? '' : 's'} attached
+                </span>
+              )}
+            </>
+          )}
           <div className="form-meta">
             <span>{Math.max(0, remaining)} characters left</span>
             <button type="submit" disabled={status === 'loading'}>
-              {status === 'loading' ? 'Sending…' : 'Send message'}
+              {status === 'loading' ? 'Sending…' : editing ? 'Save changes' : 'Send message'}
             </button>
           </div>
         </form>
         {status === 'success' && (
-          <div className="message-success">Thanks! Your message is on its way.</div>
+          <div className="message-success">
+            Thanks! Your message is on its way.
+            {receiptStillEditable(receipt) && (
+              <span className="receipt-actions">
+                {' '}
+                Made a typo?{' '}
+                <button type="button" className="link-button" onClick={startEditing}>
+                  Edit
+                </button>{' '}
+                <button type="button" className="link-button" onClick={handleDelete}>
+                  Delete
+                </button>
+              </span>
+            )}
+          </div>
         )}
+        {removed && <div className="message-success">Your message was removed.</div>}
         {status === 'error' && error && <div className="message-error">Error: {error}</div>}      </section>
 
       <section className="panel">
         <h2>Prefer to speak?</h2>
         <p className="panel-subtitle">Record up to 60 seconds.</p>
-        <VoiceRecorder defaultName={name} onNameChange={setName} />
+        <VoiceRecorder defaultName={name} onNameChange={setName} limits={limits} />
+      </section>
+
+      <section className="panel">
+        <h2>Say it on camera</h2>
+        <p className="panel-subtitle">Record a short video message.</p>
+        <VideoRecorder defaultName={name} onNameChange={setName} limits={limits} />
+      </section>
+
+      <section className="panel">
+        <h2>Add a selfie</h2>
+        <p className="panel-subtitle">Share a photo from the day.</p>
+        <PhotoUploader defaultName={name} onNameChange={setName} />
       </section>
     </div>
   )
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	editTokenHeaderName    = "X-Edit-Token"
+	defaultGuestEditWindow = 15 * time.Minute
+)
+
+var (
+	errEditNotFound     = errors.New("entry not found")
+	errEditForbidden    = errors.New("edit token does not match")
+	errEditWindowClosed = errors.New("edit window has closed")
+)
+
+// submissionReceipt is returned from /message and /voice-message. The edit
+// token is shown only here; the server keeps just its hash.
+type submissionReceipt struct {
+	Status        string     `json:"status"`
+	ID            int        `json:"id"`
+	EntryID       int        `json:"entry_id,omitempty"`
+	EditToken     string     `json:"edit_token,omitempty"`
+	EditableUntil *time.Time `json:"editable_until,omitempty"`
+}
+
+// newEditToken returns a raw token for the guest and the hash to store. Both
+// are empty when guest editing is disabled.
+func (s *server) newEditToken() (string, string, error) {
+	if s.guestEditWindow <= 0 {
+		return "", "", nil
+	}
+	raw, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	return raw, hashToken(raw), nil
+}
+
+func (s *server) receipt(id, entryID int, editToken string, createdAt time.Time) submissionReceipt {
+	rec := submissionReceipt{Status: "ok", ID: id, EntryID: entryID}
+	if editToken != "" {
+		until := createdAt.Add(s.guestEditWindow)
+		rec.EditToken, rec.EditableUntil = editToken, &until
+	}
+	return rec
+}
+
+// checkEditToken verifies that token unlocks row id of table and that the
+// grace period has not run out. table must be a trusted constant.
+func (s *server) checkEditToken(ctx context.Context, table string, id int, token string) error {
+	if s.guestEditWindow <= 0 {
+		return errEditWindowClosed
+	}
+	var storedHash string
+	var createdAt time.Time
+	err := s.pool.QueryRow(ctx, `SELECT edit_token_hash, created_at FROM `+table+` WHERE id = $1`, id).Scan(&storedHash, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errEditNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if token == "" || storedHash == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(storedHash)) != 1 {
+		return errEditForbidden
+	}
+	if time.Since(createdAt) > s.guestEditWindow {
+		return errEditWindowClosed
+	}
+	return nil
+}
+
+func writeEditError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errEditNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errEditForbidden):
+		clientError(w, r, "invalid edit token", http.StatusForbidden)
+	case errors.Is(err, errEditWindowClosed):
+		clientError(w, r, "this entry can no longer be changed", http.StatusForbidden)
+	default:
+		slog.ErrorContext(r.Context(), "check edit token", "err", err)
+		serverError(w, r, "failed to update entry")
+	}
+}
+
+func setGuestEditCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+editTokenHeaderName)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
+}
+
+// guestEditID extracts {id} from /message/{id} or /voice-message/{id}.
+func guestEditID(path, prefix string) (int, bool) {
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(path, prefix), "/"))
+	return id, err == nil && id > 0
+}
+
+// handleMessageEdit lets the holder of a receipt fix (PATCH) or retract
+// (DELETE) their text message at /message/{id}.
+func (s *server) handleMessageEdit(w http.ResponseWriter, r *http.Request) {
+	setGuestEditCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, ok := guestEditID(r.URL.Path, "/message/")
+	if !ok || (r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	var payload struct {
+		Name *string `json:"name"`
+		Text *string `json:"text"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid message payload", http.StatusBadRequest)
+			return
+		}
+		if payload.Name == nil && payload.Text == nil {
+			clientError(w, r, "nothing to update", http.StatusBadRequest)
+			return
+		}
+		if payload.Name != nil {
+			name := strings.TrimSpace(*payload.Name)
+			if name == "" {
+				clientError(w, r, "name is required", http.StatusBadRequest)
+				return
+			}
+			if len([]rune(name)) > s.limits.MaxNameLength {
+				clientError(w, r, "name is too long", http.StatusBadRequest)
+				return
+			}
+			payload.Name = &name
+		}
+		if payload.Text != nil {
+			text := strings.TrimSpace(*payload.Text)
+			if text == "" {
+				clientError(w, r, "message cannot be empty", http.StatusBadRequest)
+				return
+			}
+			if len([]rune(text)) > s.limits.MaxMessageLength {
+				clientError(w, r, "message too long", http.StatusBadRequest)
+				return
+			}
+			payload.Text = &text
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := s.checkEditToken(ctx, "messages", id, r.Header.Get(editTokenHeaderName)); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := s.deleteEntryItem(ctx, "messages", id); err != nil {
+			slog.ErrorContext(r.Context(), "delete message", "err", err)
+			serverError(w, r, "failed to delete message")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if err := s.updateMessage(ctx, id, payload.Name, payload.Text); err != nil {
+		slog.ErrorContext(r.Context(), "update message", "err", err)
+		serverError(w, r, "failed to update message")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// updateMessage changes the name or text of one message. A new name is the
+// name of the whole entry, so it is applied to the entry and its other
+// items too, as updateEntry does.
+func (s *server) updateMessage(ctx context.Context, id int, name, text *string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if name != nil {
+		var entryID *int
+		if err := tx.QueryRow(ctx, `SELECT entry_id FROM messages WHERE id = $1`, id).Scan(&entryID); err != nil {
+			return err
+		}
+		if entryID != nil {
+			if err := renameEntry(ctx, tx, *entryID, *name); err != nil {
+				return err
+			}
+		}
+	}
+	const update = `UPDATE messages SET guest_name = COALESCE($2, guest_name), text = COALESCE($3, text), edited_at = NOW() WHERE id = $1`
+	if _, err := tx.Exec(ctx, update, id, name, text); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// handleVoiceMessageEdit lets the holder of a receipt change the note
+// (PATCH) or delete (DELETE) their voice message at /voice-message/{id}.
+func (s *server) handleVoiceMessageEdit(w http.ResponseWriter, r *http.Request) {
+	setGuestEditCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, ok := guestEditID(r.URL.Path, "/voice-message/")
+	if !ok || (r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	var payload struct {
+		Note string `json:"note"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid voice message payload", http.StatusBadRequest)
+			return
+		}
+		payload.Note = strings.TrimSpace(payload.Note)
+		if len([]rune(payload.Note)) > s.limits.MaxMessageLength {
+			clientError(w, r, "note too long", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := s.checkEditToken(ctx, "voice_messages", id, r.Header.Get(editTokenHeaderName)); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := s.deleteEntryItem(ctx, "voice_messages", id); err != nil {
+			slog.ErrorContext(r.Context(), "delete voice message", "err", err)
+			serverError(w, r, "failed to delete voice message")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if _, err := s.pool.Exec(ctx, `UPDATE voice_messages SET note = $2, edited_at = NOW() WHERE id = $1`, id, payload.Note); err != nil {
+		slog.ErrorContext(r.Context(), "update voice message", "err", err)
+		serverError(w, r, "failed to update voice message")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// blobTables are the tables whose BYTEA columns hold guest recordings and
+// photos; readiness reads one value from each.
+var blobTables = []struct{ table, column string }{
+	{"voice_messages", "audio"},
+	{"photo_messages", "image"},
+	{"video_messages", "video"},
+}
+
+// handleHealthz reports that the process is up and serving. It checks
+// nothing else, so a database outage does not get the container killed.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the server can take traffic: the database
+// answers, no migrations are pending, stored media can be read and the
+// frontend build is in place. It answers 503 naming the failing checks;
+// the reasons go to the log, since anyone can call it.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+	record := func(name string, err error) bool {
+		if err != nil {
+			slog.WarnContext(r.Context(), "readiness check failed", "check", name, "err", err)
+			checks[name] = "failed"
+			ready = false
+			return false
+		}
+		checks[name] = "ok"
+		return true
+	}
+	if record("database", s.pool.Ping(ctx)) {
+		record("migrations", s.checkMigrations(ctx))
+		record("blobs", s.checkBlobs(ctx))
+	} else {
+		checks["migrations"], checks["blobs"] = "skipped", "skipped"
+	}
+	if s.readyRequiresFrontend {
+		record("static", checkStatic())
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+func (s *server) checkMigrations(ctx context.Context) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+	states, err := migrationStates(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if st.pending() {
+			return fmt.Errorf("migration %d %s is pending", st.version, st.name)
+		}
+	}
+	return nil
+}
+
+// checkBlobs reads the size of one stored file per media table, which
+// fails when the table or its TOAST storage cannot be read.
+func (s *server) checkBlobs(ctx context.Context) error {
+	for _, b := range blobTables {
+		var size int64
+		err := s.pool.QueryRow(ctx, `SELECT octet_length(`+b.column+`) FROM `+b.table+` LIMIT 1`).Scan(&size)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%s: %w", b.table, err)
+		}
+	}
+	return nil
+}
+
+func checkStatic() error {
+	_, err := os.Stat(filepath.Join(staticDir, staticIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s not found; run `npm run build` inside frontend/", filepath.Join(staticDir, staticIndexFile))
+	}
+	return err
+}
+
+// buildVersion is what /version reports, read from the information the Go
+// toolchain embeds in the binary.
+type buildVersion struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func readBuildVersion() buildVersion {
+	v := buildVersion{Version: "unknown"}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.GoVersion = info.GoVersion
+	if info.Main.Version != "" {
+		v.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v.Revision = setting.Value
+		case "vcs.time":
+			v.Time = setting.Value
+		case "vcs.modified":
+			v.Modified = setting.Value == "true"
+		}
+	}
+	return v
+}
+
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, readBuildVersion())
+}
+
+// runHealthcheck implements `healthcheck [-url u]`: it asks a running
+// server whether it is ready, for container health checks in images
+// without curl.
+func runHealthcheck(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	opts := configFlags(flags)
+	url := flags.String("url", "", "endpoint to check (default: /readyz on the configured port)")
+	timeout := flags.Duration("timeout", 5*time.Second, "give up after this long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	if *url == "" {
+		cfg, err := opts.load()
+		if err != nil {
+			return fmt.Errorf("invalid config:\n%w", err)
+		}
+		*url = "http://localhost:" + cfg.Port + "/readyz"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s %s", *url, resp.Status, body)
+	}
+	_, err = stdout.Write(body)
+	return err
+}
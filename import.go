@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// importSource is what an archive or export file holds. Voice clips are
+// read from files only when they are inserted.
+type importSource struct {
+	messages []message
+	voices   []archiveVoice
+	files    fs.FS
+	// skipped counts voice rows of an export file, which carry no audio.
+	skipped int
+}
+
+type importStats struct {
+	messages, messageDuplicates int
+	voices, voiceDuplicates     int
+	entries                     int
+}
+
+// runImport implements `import [-dry-run] <path>`. path is a ZIP from
+// /admin/archive.zip, the same archive unpacked into a directory, or a
+// .json/.ndjson file from /admin/export (text messages only). Everything
+// goes in one transaction; rows already in the database, by content hash,
+// are skipped.
+func runImport(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	opts := configFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "report what would be imported and roll back")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: import [-dry-run] <archive.zip | directory | export.json | export.ndjson>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one path")
+	}
+
+	src, closeSrc, err := openImportSource(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	cfg, err := opts.load()
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	pool, err := openDB(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	stats, err := importRows(ctx, tx, src, cfg.Transcription.Transcriber != "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "messages: %d new, %d already present\n", stats.messages, stats.messageDuplicates)
+	fmt.Fprintf(stdout, "voice notes: %d new, %d already present\n", stats.voices, stats.voiceDuplicates)
+	if src.skipped > 0 {
+		fmt.Fprintf(stdout, "voice notes without audio skipped: %d\n", src.skipped)
+	}
+	fmt.Fprintf(stdout, "entries created: %d\n", stats.entries)
+	if *dryRun {
+		fmt.Fprintln(stdout, "dry run: nothing was written")
+		return nil
+	}
+	return tx.Commit(ctx)
+}
+
+func openImportSource(p string) (*importSource, func(), error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := &importSource{}
+	closeSrc := func() {}
+	switch {
+	case info.IsDir():
+		src.files = os.DirFS(p)
+	case strings.HasSuffix(strings.ToLower(p), ".zip"):
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		src.files, closeSrc = zr, func() { zr.Close() }
+	default:
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		if err := readExportFile(f, src); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+		return src, closeSrc, nil
+	}
+
+	if err := readJSONFile(src.files, "messages.json", &src.messages); err != nil {
+		closeSrc()
+		return nil, nil, err
+	}
+	if err := readJSONFile(src.files, "voice-messages.json", &src.voices); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		closeSrc()
+		return nil, nil, err
+	}
+	return src, closeSrc, nil
+}
+
+func readJSONFile(files fs.FS, name string, v any) error {
+	raw, err := fs.ReadFile(files, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// readExportFile reads /admin/export output, either one JSON array or
+// NDJSON.
+func readExportFile(r io.Reader, src *importSource) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var rows []exportRow
+		if raw[0] == '[' {
+			if err := json.Unmarshal(raw, &rows); err != nil {
+				return err
+			}
+		} else {
+			var row exportRow
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		for _, row := range rows {
+			if row.Type == "voice" {
+				src.skipped++
+				continue
+			}
+			src.messages = append(src.messages, message{
+				ID: row.ID, EntryID: row.EntryID, GuestName: row.GuestName, Text: row.Text, Approved: row.Approved,
+				InviteID: row.InviteID, TableLabel: row.TableLabel, CreatedAt: row.CreatedAt,
+			})
+		}
+	}
+}
+
+// messageHash identifies a text message by who wrote what, and when, so
+// two guests of the same name who both wrote "Congratulations!" stay two
+// messages. The time is taken to the microsecond Postgres stores.
+func messageHash(guestName, text string, createdAt time.Time) string {
+	sum := sha256.Sum256([]byte(guestName + "\x00" + text + "\x00" + strconv.FormatInt(createdAt.UnixMicro(), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+func audioHash(audio []byte) string {
+	sum := sha256.Sum256(audio)
+	return hex.EncodeToString(sum[:])
+}
+
+func importRows(ctx context.Context, tx pgx.Tx, src *importSource, transcribe bool) (importStats, error) {
+	var stats importStats
+	seenMessages, err := collectHashes(ctx, tx, `SELECT guest_name, text, created_at FROM messages`, func(row pgx.Row) (string, error) {
+		var name, text string
+		var createdAt time.Time
+		err := row.Scan(&name, &text, &createdAt)
+		return messageHash(name, text, createdAt), err
+	})
+	if err != nil {
+		return stats, err
+	}
+	seenAudio, err := collectHashes(ctx, tx, `SELECT encode(sha256(audio), 'hex') FROM voice_messages`, func(row pgx.Row) (string, error) {
+		var hash string
+		err := row.Scan(&hash)
+		return hash, err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// Items that shared an entry in the source share a new one here.
+	entries := map[int]int{}
+	entryFor := func(sourceID int, guestName, inviteID, tableLabel string, createdAt time.Time) (int, error) {
+		if id, ok := entries[sourceID]; ok && sourceID != 0 {
+			return id, nil
+		}
+		var id int
+		const insertEntry = `INSERT INTO entries (guest_name, invite_id, table_label, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+		if err := tx.QueryRow(ctx, insertEntry, guestName, inviteID, tableLabel, createdAt).Scan(&id); err != nil {
+			return 0, err
+		}
+		stats.entries++
+		if sourceID != 0 {
+			entries[sourceID] = id
+		}
+		return id, nil
+	}
+
+	for _, m := range src.messages {
+		hash := messageHash(m.GuestName, m.Text, m.CreatedAt)
+		if seenMessages[hash] {
+			stats.messageDuplicates++
+			continue
+		}
+		seenMessages[hash] = true
+		entryID, err := entryFor(m.EntryID, m.GuestName, m.InviteID, m.TableLabel, m.CreatedAt)
+		if err != nil {
+			return stats, err
+		}
+		const insertMessage = `INSERT INTO messages (entry_id, guest_name, text, approved, invite_id, table_label, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		if _, err := tx.Exec(ctx, insertMessage, entryID, m.GuestName, m.Text, m.Approved, m.InviteID, m.TableLabel, m.CreatedAt); err != nil {
+			return stats, fmt.Errorf("message %d: %w", m.ID, err)
+		}
+		stats.messages++
+	}
+
+	for _, v := range src.voices {
+		if v.File == "" {
+			return stats, fmt.Errorf("voice message %d: no file", v.ID)
+		}
+		audio, err := fs.ReadFile(src.files, v.File)
+		if err != nil {
+			return stats, fmt.Errorf("voice message %d: %w", v.ID, err)
+		}
+		hash := audioHash(audio)
+		if seenAudio[hash] {
+			stats.voiceDuplicates++
+			continue
+		}
+		seenAudio[hash] = true
+		entryID, err := entryFor(v.EntryID, v.GuestName, v.InviteID, v.TableLabel, v.CreatedAt)
+		if err != nil {
+			return stats, err
+		}
+		// A transcript made before the export is kept; otherwise the clip
+		// is transcribed again like a new upload, if transcription is on.
+		transcriptStatus := voiceTaskDisabled
+		switch {
+		case v.Transcript != "":
+			transcriptStatus = voiceTaskDone
+		case transcribe:
+			transcriptStatus = voiceTaskPending
+		}
+		const insertVoice = `
+INSERT INTO voice_messages (entry_id, guest_name, note, audio, mime_type, duration_seconds, approved, invite_id, table_label, created_at,
+  waveform, transcript, transcript_language, transcript_status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`
+		var id int
+		if err := tx.QueryRow(ctx, insertVoice, entryID, v.GuestName, v.Note, audio, v.MimeType, v.DurationSeconds, v.Approved, v.InviteID, v.TableLabel, v.CreatedAt,
+			voiceWaveform(audio), v.Transcript, v.TranscriptLanguage, transcriptStatus).Scan(&id); err != nil {
+			return stats, fmt.Errorf("voice message %d: %w", v.ID, err)
+		}
+		if err := enqueueJob(ctx, tx, jobNormalizeVoice, voiceJob{VoiceMessageID: id}); err != nil {
+			return stats, err
+		}
+		if transcriptStatus == voiceTaskPending {
+			if err := enqueueJob(ctx, tx, jobTranscribeVoice, voiceJob{VoiceMessageID: id}); err != nil {
+				return stats, err
+			}
+		}
+		stats.voices++
+	}
+	return stats, nil
+}
+
+func collectHashes(ctx context.Context, tx pgx.Tx, query string, scan func(pgx.Row) (string, error)) (map[string]bool, error) {
+	hashes, err := collectRows(ctx, tx, query, scan)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		seen[h] = true
+	}
+	return seen, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageHash(t *testing.T) {
+	at := time.Date(2026, 6, 14, 18, 30, 0, 123456789, time.UTC)
+	base := messageHash("Anna", "Congratulations!", at)
+
+	// The same message read back from Postgres, in another zone and
+	// truncated to microseconds, is a duplicate.
+	if h := messageHash("Anna", "Congratulations!", at.Truncate(time.Microsecond).In(time.FixedZone("CEST", 2*3600))); h != base {
+		t.Error("re-import of the same message hashed differently")
+	}
+	// Another guest named Anna who wrote the same words later is not.
+	if h := messageHash("Anna", "Congratulations!", at.Add(time.Minute)); h == base {
+		t.Error("messages written at different times hashed the same")
+	}
+	if h := messageHash("Ann", "aCongratulations!", at); h == base {
+		t.Error("name and text run together")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Invite kinds. An event invite is shared by everyone (e.g. a single QR at the
+// entrance), table invites go on table cards and guest invites are personal.
+const (
+	inviteKindEvent = "event"
+	inviteKindTable = "table"
+	inviteKindGuest = "guest"
+)
+
+const (
+	inviteHeaderName  = "X-Invite-Token"
+	inviteQueryParam  = "invite"
+	maxInviteLabelLen = 80
+	maxInvitesPerMint = 200
+)
+
+var (
+	errInviteMissing = errors.New("invite token missing")
+	errInviteInvalid = errors.New("invite token invalid")
+	errInviteExpired = errors.New("invite token expired")
+)
+
+// inviteClaims is the signed payload of a guest access link. Keys are short
+// to keep the QR codes small.
+type inviteClaims struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	Kind    string `json:"k"`
+	Event   string `json:"ev,omitempty"`
+	Table   string `json:"t,omitempty"`
+	Guest   string `json:"g,omitempty"`
+	Expires int64  `json:"exp,omitempty"`
+}
+
+type inviteConfig struct {
+	secret    []byte
+	required  bool
+	event     string
+	publicURL string
+}
+
+func inviteConfigFrom(c *config) inviteConfig {
+	return inviteConfig{
+		secret:    []byte(c.Invites.Secret),
+		required:  c.Invites.Required,
+		event:     c.Invites.EventName,
+		publicURL: strings.TrimRight(c.Invites.PublicURL, "/"),
+	}
+}
+
+func (s *server) signInvite(claims inviteClaims) (string, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, s.invites.secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *server) verifyInvite(token string) (*inviteClaims, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, errInviteMissing
+	}
+	if len(s.invites.secret) == 0 {
+		return nil, errInviteInvalid
+	}
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInviteInvalid
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+	mac := hmac.New(sha256.New, s.invites.secret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(gotSig, mac.Sum(nil)) {
+		return nil, errInviteInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInviteInvalid
+	}
+	var claims inviteClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Version != 1 || claims.ID == "" {
+		return nil, errInviteInvalid
+	}
+	if claims.Expires > 0 && time.Now().Unix() > claims.Expires {
+		return nil, errInviteExpired
+	}
+	return &claims, nil
+}
+
+// inviteForSubmission checks the token a guest submitted with. It returns nil
+// claims (and no error) when invites are optional and the token is missing or
+// unusable, so old links keep working until INVITES_REQUIRED is switched on.
+func (s *server) inviteForSubmission(token string) (*inviteClaims, error) {
+	claims, err := s.verifyInvite(token)
+	if err == nil {
+		return claims, nil
+	}
+	if !s.invites.required {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// inviteErrorMessage turns an invite error into the text shown to guests.
+func inviteErrorMessage(err error) string {
+	if errors.Is(err, errInviteExpired) {
+		return "this invite link has expired"
+	}
+	return "a valid invite link is required; please scan the QR code again"
+}
+
+// inviteColumns returns the values stored alongside an entry: invite ID, kind,
+// table label and guest label. All are empty for uninvited entries.
+func inviteColumns(c *inviteClaims) (string, string, string, string) {
+	if c == nil {
+		return "", "", "", ""
+	}
+	return c.ID, c.Kind, c.Table, c.Guest
+}
+
+type mintedInvite struct {
+	Kind      string     `json:"kind"`
+	Event     string     `json:"event"`
+	Table     string     `json:"table,omitempty"`
+	Guest     string     `json:"guest,omitempty"`
+	ID        string     `json:"id"`
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleMintInvites mints signed access links (POST /admin/invites). A
+// request may ask for one event link, or for many table or guest links at
+// once, e.g. {"kind":"table","tables":["1","2","3"],"expires_in":"72h"}.
+func (s *server) handleMintInvites(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if len(s.invites.secret) == 0 {
+		clientError(w, r, "INVITE_SECRET is not configured", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var payload struct {
+		Kind      string   `json:"kind"`
+		Event     string   `json:"event"`
+		Tables    []string `json:"tables"`
+		Guests    []string `json:"guests"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		clientError(w, r, "invalid invite payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Event == "" {
+		payload.Event = s.invites.event
+	}
+
+	expiresAt, err := parseInviteExpiry(payload.ExpiresIn)
+	if err != nil {
+		clientError(w, r, "invalid expires_in", http.StatusBadRequest)
+		return
+	}
+
+	var labels []string
+	switch payload.Kind {
+	case inviteKindEvent:
+		labels = []string{""}
+	case inviteKindTable:
+		labels = payload.Tables
+	case inviteKindGuest:
+		labels = payload.Guests
+	default:
+		clientError(w, r, "kind must be event, table or guest", http.StatusBadRequest)
+		return
+	}
+	if len(labels) == 0 {
+		clientError(w, r, "at least one table or guest is required", http.StatusBadRequest)
+		return
+	}
+	if len(labels) > maxInvitesPerMint {
+		clientError(w, r, "too many invites in one request", http.StatusBadRequest)
+		return
+	}
+
+	base := s.publicBaseURL(r)
+	out := make([]mintedInvite, 0, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if payload.Kind != inviteKindEvent && label == "" {
+			clientError(w, r, "table and guest labels cannot be empty", http.StatusBadRequest)
+			return
+		}
+		if len([]rune(label)) > maxInviteLabelLen {
+			clientError(w, r, "label is too long", http.StatusBadRequest)
+			return
+		}
+		inv, err := s.newInvite(payload.Kind, payload.Event, label, expiresAt, base)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "mint invite", "err", err)
+			serverError(w, r, "failed to mint invites")
+			return
+		}
+		out = append(out, inv)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// newInvite signs a single invite. label is the table or guest name and is
+// ignored for event invites.
+func (s *server) newInvite(kind, event, label string, expiresAt *time.Time, base string) (mintedInvite, error) {
+	id, err := randomToken(6)
+	if err != nil {
+		return mintedInvite{}, err
+	}
+	claims := inviteClaims{Version: 1, ID: id, Kind: kind, Event: event}
+	if expiresAt != nil {
+		claims.Expires = expiresAt.Unix()
+	}
+	switch kind {
+	case inviteKindTable:
+		claims.Table = label
+	case inviteKindGuest:
+		claims.Guest = label
+	}
+	token, err := s.signInvite(claims)
+	if err != nil {
+		return mintedInvite{}, err
+	}
+	return mintedInvite{
+		Kind:      claims.Kind,
+		Event:     claims.Event,
+		Table:     claims.Table,
+		Guest:     claims.Guest,
+		ID:        claims.ID,
+		Token:     token,
+		URL:       inviteURL(base, token),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// parseInviteExpiry turns an optional duration such as "72h" into an expiry
+// time, truncated to whole seconds as stored in the token.
+func parseInviteExpiry(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return nil, errors.New("invalid duration")
+	}
+	t := time.Now().Add(d).Truncate(time.Second)
+	return &t, nil
+}
+
+// handleInviteTables summarises submissions per table (GET /admin/invites/tables).
+func (s *server) handleInviteTables(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	const query = `
+SELECT table_label, SUM(texts)::int, SUM(voices)::int, SUM(photos)::int, SUM(videos)::int FROM (
+  SELECT table_label, 1 AS texts, 0 AS voices, 0 AS photos, 0 AS videos FROM messages WHERE table_label <> ''
+  UNION ALL
+  SELECT table_label, 0, 1, 0, 0 FROM voice_messages WHERE table_label <> ''
+  UNION ALL
+  SELECT table_label, 0, 0, 1, 0 FROM photo_messages WHERE table_label <> ''
+  UNION ALL
+  SELECT table_label, 0, 0, 0, 1 FROM video_messages WHERE table_label <> ''
+) t GROUP BY table_label ORDER BY table_label`
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query invite tables", "err", err)
+		serverError(w, r, "failed to fetch tables")
+		return
+	}
+	defer rows.Close()
+
+	type tableSummary struct {
+		Table         string `json:"table"`
+		Messages      int    `json:"messages"`
+		VoiceMessages int    `json:"voice_messages"`
+		PhotoMessages int    `json:"photo_messages"`
+		VideoMessages int    `json:"video_messages"`
+	}
+	out := []tableSummary{}
+	for rows.Next() {
+		var t tableSummary
+		if err := rows.Scan(&t.Table, &t.Messages, &t.VoiceMessages, &t.PhotoMessages, &t.VideoMessages); err != nil {
+			slog.ErrorContext(r.Context(), "scan invite table", "err", err)
+			serverError(w, r, "failed to read tables")
+			return
+		}
+		out = append(out, t)
+	}
+	if rows.Err() != nil {
+		slog.ErrorContext(r.Context(), "read invite table rows", "err", rows.Err())
+		serverError(w, r, "failed to read tables")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
+
+// publicBaseURL is the guest-facing origin used in links. PUBLIC_URL wins;
+// otherwise it is derived from the admin's own request.
+func (s *server) publicBaseURL(r *http.Request) string {
+	if s.invites.publicURL != "" {
+		return s.invites.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || (s.trustProxyHeaders && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func inviteURL(base, token string) string {
+	return base + "/?" + url.Values{inviteQueryParam: {token}}.Encode()
+}
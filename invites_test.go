@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyInvite(t *testing.T) {
+	s := &server{invites: inviteConfig{secret: []byte("invite secret")}}
+	otherKey := &server{invites: inviteConfig{secret: []byte("other secret")}}
+	valid := inviteClaims{Version: 1, ID: "inv1", Kind: inviteKindTable, Table: "Table 4", Expires: time.Now().Add(time.Hour).Unix()}
+	sign := func(s *server, c inviteClaims) string {
+		token, err := s.signInvite(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	validToken := sign(s, valid)
+	payload, sig, _ := strings.Cut(validToken, ".")
+	swapped := sign(s, inviteClaims{Version: 1, ID: "inv1", Kind: inviteKindTable, Table: "Table 1"})
+	swappedPayload, _, _ := strings.Cut(swapped, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", validToken, nil},
+		{"surrounding spaces", " " + validToken + "\n", nil},
+		{"no expiry", sign(s, inviteClaims{Version: 1, ID: "inv2", Kind: inviteKindEvent}), nil},
+		{"expired", sign(s, inviteClaims{Version: 1, ID: "inv3", Kind: inviteKindGuest, Expires: time.Now().Add(-time.Minute).Unix()}), errInviteExpired},
+		{"tampered payload", swappedPayload + "." + sig, errInviteInvalid},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(sig)), errInviteInvalid},
+		{"wrong key", sign(otherKey, valid), errInviteInvalid},
+		{"missing", "  ", errInviteMissing},
+		{"no signature", payload, errInviteInvalid},
+		{"signature not base64", payload + ".!!!", errInviteInvalid},
+		{"payload not JSON", "bm90IGpzb24." + sig, errInviteInvalid},
+		{"signed garbage", sign(s, inviteClaims{}), errInviteInvalid},
+		{"wrong version", sign(s, inviteClaims{Version: 2, ID: "inv4", Kind: inviteKindEvent}), errInviteInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := s.verifyInvite(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.ID == "" || claims.Version != 1 {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+
+	claims, err := s.verifyInvite(validToken)
+	if err != nil || *claims != valid {
+		t.Errorf("verifyInvite = %+v, %v; want %+v", claims, err, valid)
+	}
+	if _, err := (&server{}).verifyInvite(validToken); !errors.Is(err, errInviteInvalid) {
+		t.Errorf("without a secret: err = %v, want errInviteInvalid", err)
+	}
+}
+
+func TestInviteForSubmission(t *testing.T) {
+	optional := &server{invites: inviteConfig{secret: []byte("invite secret")}}
+	required := &server{invites: inviteConfig{secret: []byte("invite secret"), required: true}}
+	if c, err := optional.inviteForSubmission("garbage"); c != nil || err != nil {
+		t.Errorf("optional invites: got %+v, %v; want no claims and no error", c, err)
+	}
+	if _, err := required.inviteForSubmission(""); !errors.Is(err, errInviteMissing) {
+		t.Errorf("required invites, no token: err = %v, want errInviteMissing", err)
+	}
+	if _, err := required.inviteForSubmission("garbage"); !errors.Is(err, errInviteInvalid) {
+		t.Errorf("required invites, bad token: err = %v, want errInviteInvalid", err)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Job states. A failed attempt goes back to queued with a later run_at
+// until the handler's attempts are used up, or at once for a permanent
+// failure; then the job is dead and waits for an admin to retry it.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobDead    = "dead"
+)
+
+const (
+	jobPollInterval   = 5 * time.Second
+	jobReapInterval   = 30 * time.Second
+	jobMaxBackoff     = time.Hour
+	jobListLimit      = 200
+	defaultJobTimeout = 2 * time.Minute
+)
+
+// errJobPermanent marks a failure that retrying cannot fix, such as a
+// payload that does not decode. Handlers wrap errors with jobPermanent and
+// the job is dead after that attempt instead of backing off.
+var errJobPermanent = errors.New("permanent job failure")
+
+type permanentJobError struct{ err error }
+
+func (e permanentJobError) Error() string        { return e.err.Error() }
+func (e permanentJobError) Unwrap() error        { return e.err }
+func (e permanentJobError) Is(target error) bool { return target == errJobPermanent }
+
+// jobPermanent wraps err so runNext gives up on the job; its message is
+// unchanged.
+func jobPermanent(err error) error {
+	return permanentJobError{err}
+}
+
+// dbExecer is satisfied by the pool and by a transaction, so a job can be
+// enqueued atomically with the row it is about.
+type dbExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// jobOptions configure one kind of job. Zero values get defaults.
+type jobOptions struct {
+	concurrency int           // workers running this kind at once
+	maxAttempts int           // attempts before the job is dead
+	timeout     time.Duration // per attempt
+	backoff     time.Duration // first retry delay, doubled per attempt
+}
+
+type jobHandler struct {
+	kind   string
+	opts   jobOptions
+	run    func(ctx context.Context, payload []byte) error
+	onFail func(ctx context.Context, payload []byte, err error, dead bool)
+	wake   chan struct{}
+}
+
+// jobQueue runs jobs stored in the jobs table. Workers claim rows with
+// SELECT ... FOR UPDATE SKIP LOCKED, so several server processes can share
+// the table. A running job holds a lease; if its process dies the lease
+// runs out and the job is queued again.
+type jobQueue struct {
+	pool     *pgxpool.Pool
+	handlers map[string]*jobHandler
+	running  sync.WaitGroup
+}
+
+func newJobQueue(pool *pgxpool.Pool) *jobQueue {
+	return &jobQueue{pool: pool, handlers: map[string]*jobHandler{}}
+}
+
+// registerJob adds the handler for kind; payloads are decoded into T.
+// onFail, if set, is told about every failed attempt. Call before start.
+func registerJob[T any](q *jobQueue, kind string, opts jobOptions, run func(ctx context.Context, payload T) error, onFail func(ctx context.Context, payload T, err error, dead bool)) {
+	if opts.concurrency <= 0 {
+		opts.concurrency = 1
+	}
+	if opts.maxAttempts <= 0 {
+		opts.maxAttempts = 5
+	}
+	if opts.timeout <= 0 {
+		opts.timeout = defaultJobTimeout
+	}
+	if opts.backoff <= 0 {
+		opts.backoff = 30 * time.Second
+	}
+	h := &jobHandler{kind: kind, opts: opts, wake: make(chan struct{}, opts.concurrency)}
+	h.run = func(ctx context.Context, raw []byte) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return jobPermanent(fmt.Errorf("decode payload: %w", err))
+		}
+		return run(ctx, payload)
+	}
+	if onFail != nil {
+		h.onFail = func(ctx context.Context, raw []byte, err error, dead bool) {
+			var payload T
+			if json.Unmarshal(raw, &payload) == nil {
+				onFail(ctx, payload, err, dead)
+			}
+		}
+	}
+	q.handlers[kind] = h
+}
+
+// kinds lists the registered job kinds in order.
+func (q *jobQueue) kinds() []string {
+	return slices.Sorted(maps.Keys(q.handlers))
+}
+
+// enqueueJob stores a job. Jobs of a kind with no registered handler wait
+// in the table until a server that has one starts.
+func enqueueJob(ctx context.Context, db dbExecer, kind string, payload any) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `INSERT INTO jobs (kind, payload) VALUES ($1, $2)`, kind, raw)
+	return err
+}
+
+// notify wakes the local workers for kinds that just got jobs; other
+// processes find them on their next poll. Safe on a nil queue.
+func (q *jobQueue) notify(kinds ...string) {
+	if q == nil {
+		return
+	}
+	for _, kind := range kinds {
+		if h := q.handlers[kind]; h != nil {
+			select {
+			case h.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// start launches the workers and the lease reaper; they stop with ctx,
+// and wait blocks until they have.
+func (q *jobQueue) start(ctx context.Context) {
+	for _, h := range q.handlers {
+		for range h.opts.concurrency {
+			q.running.Go(func() { q.work(ctx, h) })
+		}
+	}
+	q.running.Go(func() { q.reap(ctx) })
+}
+
+func (q *jobQueue) wait() {
+	q.running.Wait()
+}
+
+func (q *jobQueue) work(ctx context.Context, h *jobHandler) {
+	for {
+		for q.runNext(ctx, h) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// reap queues running jobs whose lease expired, i.e. whose worker died.
+func (q *jobQueue) reap(ctx context.Context) {
+	for {
+		reapCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		tag, err := q.pool.Exec(reapCtx, `UPDATE jobs SET status = $1, updated_at = NOW() WHERE status = $2 AND locked_until < NOW()`, jobQueued, jobRunning)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "reap jobs", "err", err)
+		} else if n := tag.RowsAffected(); n > 0 {
+			slog.InfoContext(ctx, "requeued jobs with expired leases", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobReapInterval):
+		}
+	}
+}
+
+// runNext claims and runs one due job of h's kind. It reports false when
+// there was nothing to do or the database could not be reached.
+func (q *jobQueue) runNext(ctx context.Context, h *jobHandler) bool {
+	claimCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	const claim = `
+UPDATE jobs SET status = $2, attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $3), updated_at = NOW()
+WHERE id = (
+  SELECT id FROM jobs WHERE kind = $1 AND status = $4 AND run_at <= NOW()
+  ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+)
+RETURNING id, payload, attempts`
+	lease := (h.opts.timeout + time.Minute).Seconds()
+	var id int64
+	var payload []byte
+	var attempts int
+	err := q.pool.QueryRow(claimCtx, claim, h.kind, jobRunning, lease, jobQueued).Scan(&id, &payload, &attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "claim job", "kind", h.kind, "err", err)
+		}
+		return false
+	}
+
+	err = runJob(ctx, h, payload)
+
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancelSave()
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: the attempt was cut short, not failed.
+		const release = `UPDATE jobs SET status = $2, attempts = attempts - 1, locked_until = NULL, updated_at = NOW() WHERE id = $1`
+		if _, err := q.pool.Exec(saveCtx, release, id, jobQueued); err != nil {
+			slog.ErrorContext(ctx, "release job", "kind", h.kind, "job", id, "err", err)
+		}
+		return false
+	}
+	if err == nil {
+		const done = `UPDATE jobs SET status = $2, last_error = '', locked_until = NULL, finished_at = NOW(), updated_at = NOW() WHERE id = $1`
+		if _, err := q.pool.Exec(saveCtx, done, id, jobDone); err != nil {
+			slog.ErrorContext(ctx, "finish job", "kind", h.kind, "job", id, "err", err)
+		}
+		return true
+	}
+
+	permanent := errors.Is(err, errJobPermanent)
+	dead := permanent || attempts >= h.opts.maxAttempts
+	slog.WarnContext(ctx, "job attempt failed", "kind", h.kind, "job", id, "attempt", attempts, "max_attempts", h.opts.maxAttempts, "permanent", permanent, "err", err)
+	if dead {
+		const bury = `UPDATE jobs SET status = $2, last_error = $3, locked_until = NULL, finished_at = NOW(), updated_at = NOW() WHERE id = $1`
+		_, err2 := q.pool.Exec(saveCtx, bury, id, jobDead, err.Error())
+		if err2 != nil {
+			slog.ErrorContext(ctx, "bury job", "kind", h.kind, "job", id, "err", err2)
+		}
+	} else {
+		const retry = `UPDATE jobs SET status = $2, last_error = $3, locked_until = NULL, run_at = NOW() + make_interval(secs => $4), updated_at = NOW() WHERE id = $1`
+		_, err2 := q.pool.Exec(saveCtx, retry, id, jobQueued, err.Error(), jobBackoff(h.opts.backoff, attempts).Seconds())
+		if err2 != nil {
+			slog.ErrorContext(ctx, "reschedule job", "kind", h.kind, "job", id, "err", err2)
+		}
+	}
+	if h.onFail != nil {
+		h.onFail(saveCtx, payload, err, dead)
+	}
+	return true
+}
+
+// runJob calls the handler with the attempt timeout, turning a panic into
+// a failed attempt.
+func runJob(ctx context.Context, h *jobHandler, payload []byte) (err error) {
+	runCtx, cancel := context.WithTimeout(ctx, h.opts.timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h.run(runCtx, payload)
+}
+
+// jobBackoff doubles base per attempt up to jobMaxBackoff, with up to 20%
+// jitter so failing jobs do not retry in lockstep.
+func jobBackoff(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < jobMaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, jobMaxBackoff)
+	return d + time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+type job struct {
+	ID         int64           `json:"id"`
+	Kind       string          `json:"kind"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	RunAt      time.Time       `json:"run_at"`
+	LastError  string          `json:"last_error"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+const jobColumns = `id, kind, payload, status, attempts, run_at, last_error, created_at, updated_at, finished_at`
+
+func scanJob(row pgx.Row) (job, error) {
+	var j job
+	err := row.Scan(&j.ID, &j.Kind, &j.Payload, &j.Status, &j.Attempts, &j.RunAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt)
+	return j, err
+}
+
+type jobCount struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// handleJobs lets admins inspect the queue and retry jobs:
+// GET /admin/jobs?status=&kind=, GET /admin/jobs/{id} and
+// POST /admin/jobs/{id}/retry.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	remainder := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/jobs"), "/")
+	switch {
+	case remainder == "" && r.Method == http.MethodGet:
+		s.listJobs(ctx, w, r)
+	case strings.HasSuffix(remainder, "/retry") && r.Method == http.MethodPost:
+		id, err := strconv.ParseInt(strings.TrimSuffix(remainder, "/retry"), 10, 64)
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		const retry = `UPDATE jobs SET status = $2, attempts = 0, run_at = NOW(), last_error = '', finished_at = NULL, updated_at = NOW() WHERE id = $1 AND status <> $3 RETURNING kind`
+		var kind string
+		err = s.pool.QueryRow(ctx, retry, id, jobQueued, jobRunning).Scan(&kind)
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			if err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)`, id).Scan(&exists); err == nil && !exists {
+				http.NotFound(w, r)
+				return
+			}
+			clientError(w, r, "job is running", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "retry job", "err", err)
+			serverError(w, r, "failed to retry job")
+			return
+		}
+		s.jobs.notify(kind)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case r.Method == http.MethodGet:
+		id, err := strconv.ParseInt(remainder, 10, 64)
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		j, err := scanJob(s.pool.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "get job", "err", err)
+			serverError(w, r, "failed to fetch job")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, j)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) listJobs(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	kind := r.URL.Query().Get("kind")
+	counts := []jobCount{}
+	rows, err := s.pool.Query(ctx, `SELECT kind, status, COUNT(*) FROM jobs GROUP BY kind, status ORDER BY kind, status`)
+	if err == nil {
+		for rows.Next() {
+			var c jobCount
+			if err = rows.Scan(&c.Kind, &c.Status, &c.Count); err != nil {
+				break
+			}
+			counts = append(counts, c)
+		}
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	jobs := []job{}
+	if err == nil {
+		const query = `SELECT ` + jobColumns + ` FROM jobs
+WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2)
+ORDER BY id DESC LIMIT $3`
+		rows, err = s.pool.Query(ctx, query, status, kind, jobListLimit)
+		if err == nil {
+			for rows.Next() {
+				var j job
+				if j, err = scanJob(rows); err != nil {
+					break
+				}
+				jobs = append(jobs, j)
+			}
+			rows.Close()
+			if err == nil {
+				err = rows.Err()
+			}
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list jobs", "err", err)
+		serverError(w, r, "failed to fetch jobs")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"counts": counts, "jobs": jobs})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestJobPermanent(t *testing.T) {
+	q := newJobQueue(nil)
+	ran := false
+	registerJob(q, "test", jobOptions{}, func(ctx context.Context, job voiceJob) error {
+		ran = true
+		return nil
+	}, nil)
+	err := q.handlers["test"].run(context.Background(), []byte(`{"voice_message_id": "one"}`))
+	if ran || !errors.Is(err, errJobPermanent) {
+		t.Errorf("bad payload: ran = %v, err = %v; want a permanent failure", ran, err)
+	}
+
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{fmt.Errorf("normalize audio/mp4: %w", errAudioUnsupported), true},
+		{fmt.Errorf("decode audio/webm: %w", errAudioEmpty), true},
+		{errors.New("whisper: exit status 1"), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		err := voiceTaskError(tt.err)
+		if errors.Is(err, errJobPermanent) != tt.permanent {
+			t.Errorf("voiceTaskError(%v) permanent = %v, want %v", tt.err, !tt.permanent, tt.permanent)
+		}
+		if err.Error() != tt.err.Error() || !errors.Is(err, tt.err) {
+			t.Errorf("voiceTaskError(%v) = %v, want the same error", tt.err, err)
+		}
+	}
+}
+
+func TestEnqueueVoiceJobs(t *testing.T) {
+	tests := []struct {
+		transcribe bool
+		want       []any
+	}{
+		{true, []any{jobNormalizeVoice, jobTranscribeVoice}},
+		{false, []any{jobNormalizeVoice, 7}},
+	}
+	for _, tt := range tests {
+		db := &execRecorder{}
+		if err := enqueueVoiceJobs(context.Background(), db, 7, tt.transcribe); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.args) != len(tt.want) {
+			t.Fatalf("transcribe=%v: ran %d statements, want %d", tt.transcribe, len(db.args), len(tt.want))
+		}
+		for i, want := range tt.want {
+			if got := db.args[i][0]; got != want {
+				t.Errorf("transcribe=%v: statement %d got %v first, want %v", tt.transcribe, i, got, want)
+			}
+		}
+		if !tt.transcribe && db.args[1][1] != voiceTaskDisabled {
+			t.Errorf("transcript status = %v, want %q", db.args[1][1], voiceTaskDisabled)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDFrom returns the ID withRequestID gave the request, if any.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts IDs from a proxy or client as long as they are
+// short and safe to put in logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID keeps the X-Request-ID a proxy sent, or makes one up, and
+// puts it on the response and in the request context, where log calls
+// with that context pick it up.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// clientError answers a request with an error message for the client.
+// Every error response goes through it: the request ID in the message
+// lets a guest or admin point support at the matching log lines.
+func clientError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	if id := requestIDFrom(r.Context()); id != "" {
+		msg += " (request ID " + id + ")"
+	}
+	http.Error(w, msg, status)
+}
+
+// serverError answers a request that failed through no fault of the
+// client.
+func serverError(w http.ResponseWriter, r *http.Request, msg string) {
+	clientError(w, r, msg, http.StatusInternalServerError)
+}
+
+// requestIDHandler adds the request ID from the context to every record.
+type requestIDHandler struct{ slog.Handler }
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogger builds the process logger: text or JSON lines on w at the
+// given level. Setting it as the slog default also routes the standard
+// log package through it.
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	lvl.UnmarshalText([]byte(level)) // validated with the config
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(requestIDHandler{h})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDReachesClients(t *testing.T) {
+	srv, err := newServer(defaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.routes()
+
+	t.Run("rejected submission", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader("{"))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(requestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", w.Code)
+		}
+		if got, want := strings.TrimSpace(w.Body.String()), "invalid message payload (request ID req-1)"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("admin error", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/admin/export?format=xml", nil)
+		r.Header.Set(requestIDHeader, "req-2")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", w.Code)
+		}
+		if got, want := strings.TrimSpace(w.Body.String()), "format must be csv, ndjson or json (request ID req-2)"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("CORS preflight", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodOptions, "/admin", nil)
+		r.Header.Set("Origin", "https://example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Header().Get(requestIDHeader) == "" {
+			t.Error("preflight response has no X-Request-ID")
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") == "" {
+			t.Error("preflight was not answered by the CORS handler")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// normalizedSampleRate is what Opus clips are decoded at for the
+	// normalized file; plenty for voice and half the size of 48 kHz.
+	normalizedSampleRate = 24000
+	normalizedMimeType   = "audio/wav"
+	// maxNormalizeGainDB stops near-silent clips from being blown up into
+	// loud hiss.
+	maxNormalizeGainDB = 20
+	// normalizePeakDBFS is the highest sample peak allowed after gain.
+	normalizePeakDBFS = -1
+)
+
+// audioNormalizer turns a stored voice message into a loudness-normalized
+// 16-bit mono WAV, which every browser plays. Opus and WAV are decoded in
+// Go; anything else (iOS records AAC in MP4) goes through ffmpeg when
+// FFMPEG_BIN is set.
+type audioNormalizer struct {
+	targetLUFS float64
+	ffmpeg     string
+}
+
+func newAudioNormalizer(c *config) (*audioNormalizer, error) {
+	n := &audioNormalizer{targetLUFS: c.Loudness.TargetLUFS}
+	if bin := c.Loudness.FFmpegBin; bin != "" {
+		var err error
+		if n.ffmpeg, err = exec.LookPath(bin); err != nil {
+			return nil, fmt.Errorf("%s: %w", setting("FFMPEG_BIN"), err)
+		}
+	}
+	return n, nil
+}
+
+// normalize returns the normalized WAV and the loudness of the original in
+// LUFS (-Inf for silence).
+func (n *audioNormalizer) normalize(ctx context.Context, audio []byte) ([]byte, float64, error) {
+	mono, rate, err := n.decode(ctx, audio, normalizedSampleRate)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	loudness := integratedLoudness(mono, rate)
+	gain := 0.0
+	if !math.IsInf(loudness, -1) {
+		gain = min(n.targetLUFS-loudness, maxNormalizeGainDB)
+	}
+	var peak float32
+	for _, v := range mono {
+		peak = max(peak, float32(math.Abs(float64(v))))
+	}
+	if peak > 0 {
+		gain = min(gain, normalizePeakDBFS-20*math.Log10(float64(peak)))
+	}
+	factor := float32(math.Pow(10, gain/20))
+	for i := range mono {
+		mono[i] *= factor
+	}
+	return encodeWAV(mono, rate), loudness, nil
+}
+
+// decode is decodeMono that hands formats Go cannot read to ffmpeg, when
+// it is configured. rate is the rate Opus and ffmpeg output is decoded at.
+func (n *audioNormalizer) decode(ctx context.Context, audio []byte, rate int) ([]float32, int, error) {
+	mono, got, err := decodeMono(audio, rate)
+	if errors.Is(err, errAudioUnsupported) && n.ffmpeg != "" {
+		var wav []byte
+		if wav, err = n.ffmpegWAV(ctx, audio, rate); err == nil {
+			mono, got, err = decodeMono(wav, rate)
+		}
+	}
+	return mono, got, err
+}
+
+// ffmpegWAV converts a clip Go cannot decode to mono WAV at rate.
+func (n *audioNormalizer) ffmpegWAV(ctx context.Context, audio []byte, rate int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "normalize-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "clip")
+	if err := os.WriteFile(input, audio, 0o600); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, n.ffmpeg, "-nostdin", "-hide_banner", "-loglevel", "error",
+		"-i", input, "-vn", "-ac", "1", "-ar", strconv.Itoa(rate), "-f", "wav", "pipe:1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// integratedLoudness measures mono audio per ITU-R BS.1770-4, the basis of
+// EBU R128: K-weighting, 400 ms blocks overlapping by 75%, an absolute gate
+// at -70 LUFS and a relative gate 10 LU below the ungated level.
+func integratedLoudness(mono []float32, rate int) float64 {
+	shelf, highPass := kWeighting(float64(rate))
+	weighted := make([]float64, len(mono))
+	for i, v := range mono {
+		weighted[i] = highPass.process(shelf.process(float64(v)))
+	}
+
+	block, hop := rate*400/1000, rate*100/1000
+	var energies []float64
+	for start := 0; start+block <= len(weighted); start += hop {
+		var sum float64
+		for _, v := range weighted[start : start+block] {
+			sum += v * v
+		}
+		energies = append(energies, sum/float64(block))
+	}
+
+	lufs := func(energy float64) float64 { return -0.691 + 10*math.Log10(energy) }
+	gated := func(threshold float64) float64 {
+		var sum float64
+		var count int
+		for _, e := range energies {
+			if lufs(e) > threshold {
+				sum += e
+				count++
+			}
+		}
+		if count == 0 {
+			return 0
+		}
+		return sum / float64(count)
+	}
+	absolute := gated(-70)
+	if absolute == 0 {
+		return math.Inf(-1)
+	}
+	relative := gated(lufs(absolute) - 10)
+	if relative == 0 {
+		return math.Inf(-1)
+	}
+	return lufs(relative)
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the BS.1770 pre-filter (a high shelf modelling the
+// head) and RLB high-pass, derived for any sample rate the same way
+// libebur128 does.
+func kWeighting(rate float64) (*biquad, *biquad) {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{
+		b0: 1, b1: -2, b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// normalizeVoiceMessage is the normalize_voice job step that stores the
+// normalized variant next to the original.
+func normalizeVoiceMessage(db dbExecer, n *audioNormalizer) voiceTask {
+	return func(ctx context.Context, id int, audio []byte, mimeType string) error {
+		wav, loudness, err := n.normalize(ctx, audio)
+		if err != nil {
+			return fmt.Errorf("normalize %s: %w", mimeType, err)
+		}
+		var measured *float64
+		if !math.IsInf(loudness, -1) {
+			measured = &loudness
+		}
+		const save = `UPDATE voice_messages SET normalized_audio = $2, normalized_mime_type = $3, loudness_lufs = $4 WHERE id = $1`
+		_, err = db.Exec(context.WithoutCancel(ctx), save, id, wav, normalizedMimeType, measured)
+		return err
+	}
+}
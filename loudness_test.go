@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func sine(freq, dbfs float64, seconds float64, rate int) []float32 {
+	amp := math.Pow(10, dbfs/20)
+	out := make([]float32, int(seconds*float64(rate)))
+	for i := range out {
+		out[i] = float32(amp * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return out
+}
+
+// BS.1770 calibrates K-weighting so that a 0 dBFS 1 kHz sine in a single
+// channel measures -3.01 LKFS; a mono clip is one channel.
+func TestIntegratedLoudnessSine(t *testing.T) {
+	for _, rate := range []int{16000, normalizedSampleRate, 44100, 48000} {
+		for _, dbfs := range []float64{0, -20, -40} {
+			got := integratedLoudness(sine(1000, dbfs, 5, rate), rate)
+			if want := dbfs - 3.01; math.Abs(got-want) > 0.05 {
+				t.Errorf("%d Hz, 1 kHz sine at %v dBFS: got %.2f LUFS, want %.2f", rate, dbfs, got, want)
+			}
+		}
+	}
+}
+
+func TestIntegratedLoudnessGating(t *testing.T) {
+	const rate = 48000
+	if got := integratedLoudness(make([]float32, rate*3), rate); !math.IsInf(got, -1) {
+		t.Errorf("silence: got %v, want -Inf", got)
+	}
+	if got := integratedLoudness(sine(1000, -75, 3, rate), rate); !math.IsInf(got, -1) {
+		t.Errorf("below the absolute gate: got %v, want -Inf", got)
+	}
+	// The quiet half is more than 10 LU below the loud half, so the
+	// relative gate leaves the loud half and the few blocks that straddle
+	// the change; ungated, this would measure about -26 LUFS.
+	clip := append(sine(1000, -20, 5, rate), sine(1000, -50, 5, rate)...)
+	if got := integratedLoudness(clip, rate); math.Abs(got-(-23.01)) > 0.25 {
+		t.Errorf("loud then quiet: got %.2f LUFS, want -23.01", got)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// blobChunkSize is how much of a stored file is fetched per query while
+// streaming, so a Range request for the tail of a video never loads the
+// whole row.
+const blobChunkSize = 1 << 20
+
+// blobWriteTimeout is how long one audio or video response may take. A
+// full video over a slow phone connection outlasts HTTP_WRITE_TIMEOUT.
+const blobWriteTimeout = 30 * time.Minute
+
+// extendWriteDeadline gives a long download d to finish, counted from now,
+// instead of the server-wide HTTP_WRITE_TIMEOUT. If the writer cannot
+// change its deadline the server-wide one stays.
+func extendWriteDeadline(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d)); err != nil {
+		slog.WarnContext(r.Context(), "extend write deadline", "err", err)
+	}
+}
+
+// readUpload reads the multipart file field, capped at limit bytes. The
+// returned error text is safe to show to the uploader.
+func readUpload(r *http.Request, field string, limit int64) ([]byte, *multipart.FileHeader, error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return nil, nil, reject(rejectEmpty, field+" file is required")
+	}
+	defer file.Close()
+	data, err := readUploadFile(file, field, limit)
+	return data, header, err
+}
+
+// readFileHeader is readUpload for one of several files sent under the same
+// field name.
+func readFileHeader(header *multipart.FileHeader, field string, limit int64) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, reject(rejectInvalid, "unable to read "+field+" file")
+	}
+	defer file.Close()
+	return readUploadFile(file, field, limit)
+}
+
+func readUploadFile(file multipart.File, field string, limit int64) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, io.LimitReader(file, limit+1)); err != nil {
+		return nil, reject(rejectInvalid, "unable to read "+field+" file")
+	}
+	if buf.Len() == 0 {
+		return nil, reject(rejectEmpty, field+" file is empty")
+	}
+	if int64(buf.Len()) > limit {
+		return nil, reject(rejectTooLarge, field+" file too large")
+	}
+	return buf.Bytes(), nil
+}
+
+// blobReader is an io.ReadSeeker over a BYTEA column that reads it in
+// chunks with substring(). table and column must be trusted constants.
+type blobReader struct {
+	ctx    context.Context
+	s      *server
+	table  string
+	column string
+	id     int
+	size   int64
+	off    int64
+	buf    []byte
+	bufOff int64
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.off >= b.size {
+		return 0, io.EOF
+	}
+	if b.off < b.bufOff || b.off >= b.bufOff+int64(len(b.buf)) {
+		ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
+		defer cancel()
+		query := `SELECT substring(` + b.column + ` FROM $2 FOR $3) FROM ` + b.table + ` WHERE id = $1`
+		var chunk []byte
+		if err := b.s.pool.QueryRow(ctx, query, b.id, b.off+1, blobChunkSize).Scan(&chunk); err != nil {
+			return 0, err
+		}
+		if len(chunk) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b.buf, b.bufOff = chunk, b.off
+	}
+	n := copy(p, b.buf[b.off-b.bufOff:])
+	b.off += int64(n)
+	return n, nil
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.off = offset
+	return offset, nil
+}
+
+// serveBlob streams a stored audio or video file with Range support. Rows
+// are looked up with a short timeout; the transfer itself follows the
+// request context. A NULL column is reported as not found.
+func (s *server) serveBlob(w http.ResponseWriter, r *http.Request, table, column, mimeColumn string, id int) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	blob := &blobReader{ctx: r.Context(), s: s, table: table, column: column, id: id}
+	var mimeType string
+	var createdAt time.Time
+	query := `SELECT octet_length(` + column + `), ` + mimeColumn + `, created_at FROM ` + table + ` WHERE id = $1 AND ` + column + ` IS NOT NULL`
+	err := s.pool.QueryRow(ctx, query, id).Scan(&blob.size, &mimeType, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "open blob", "table", table, "column", column, "err", err)
+		serverError(w, r, "failed to load file")
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	extendWriteDeadline(w, r, blobWriteTimeout)
+	http.ServeContent(w, r, "", createdAt, blob)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestExtendWriteDeadline checks that a download outlives the server-wide
+// write timeout, also behind the request recorder the router wraps it in.
+func TestExtendWriteDeadline(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		extendWriteDeadline(rec, r, time.Minute)
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(rec, "done")
+	}))
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "done" {
+		t.Fatalf("body = %q, %v; want done", body, err)
+	}
+}
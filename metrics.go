@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Submission types, the type label of the submission counters.
+const (
+	submissionText  = "text"
+	submissionVoice = "voice"
+	submissionPhoto = "photo"
+	submissionVideo = "video"
+	submissionEntry = "entry"
+)
+
+// Why a guest submission was turned away, the reason label of
+// guestbook_submissions_rejected_total.
+const (
+	rejectInvalid     = "invalid"
+	rejectInvite      = "invite"
+	rejectEmptyName   = "empty_name"
+	rejectEmpty       = "empty"
+	rejectTooLong     = "too_long"
+	rejectTooLarge    = "too_large"
+	rejectUnsupported = "unsupported_type"
+)
+
+// rejection is a validation error whose text is safe to show the guest,
+// tagged with the reason it is counted under.
+type rejection struct{ reason, msg string }
+
+func (e *rejection) Error() string { return e.msg }
+
+func reject(reason, msg string) error {
+	return &rejection{reason: reason, msg: msg}
+}
+
+// payloadRejection classifies a request body that could not be parsed.
+func payloadRejection(err error, msg string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return reject(rejectTooLarge, msg)
+	}
+	return reject(rejectInvalid, msg)
+}
+
+// rejectSubmission answers a guest submission that failed validation and
+// counts it. Errors that are not rejections count as invalid. Like server
+// errors, the message names the request ID.
+func (s *server) rejectSubmission(w http.ResponseWriter, r *http.Request, kind string, err error) {
+	reason, status := rejectInvalid, http.StatusBadRequest
+	var rej *rejection
+	if errors.As(err, &rej) {
+		reason = rej.reason
+	}
+	if reason == rejectInvite {
+		status = http.StatusForbidden
+	}
+	s.metrics.rejected.WithLabelValues(kind, reason).Inc()
+	clientError(w, r, err.Error(), status)
+}
+
+// metrics holds the collectors behind /metrics. Each server has its own
+// registry, so nothing here is global.
+type metrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	submitted *prometheus.CounterVec
+	rejected  *prometheus.CounterVec
+	graphql   *prometheus.HistogramVec
+}
+
+// latencyBuckets extend the defaults for uploads and archive downloads.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+func newMetrics(pool *pgxpool.Pool) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guestbook_http_requests_total",
+			Help: "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "guestbook_http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests by route pattern and method.",
+			Buckets: latencyBuckets,
+		}, []string{"route", "method"}),
+		submitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guestbook_submissions_total",
+			Help: "Guest submissions stored, by type.",
+		}, []string{"type"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "guestbook_submissions_rejected_total",
+			Help: "Guest submissions turned away, by type and reason.",
+		}, []string{"type", "reason"}),
+		graphql: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "guestbook_graphql_duration_seconds",
+			Help:    "Time to execute GraphQL operations by operation type and outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "outcome"}),
+	}
+	m.registry.MustRegister(m.requests, m.latency, m.submitted, m.rejected, m.graphql,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if pool != nil {
+		m.registry.MustRegister(poolCollector{pool}, storageCollector{pool})
+	}
+	return m
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// responseRecorder remembers the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// instrument counts, times and logs every request under the mux pattern
+// that served it, which keeps IDs in paths out of the labels. CORS
+// preflights never reach the mux and are counted as "preflight". Probes of
+// the health and metrics endpoints are logged at debug level only.
+func (s *server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		route := r.Pattern
+		switch {
+		case route != "":
+		case r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "":
+			route = "preflight"
+		default:
+			route = "unmatched"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		s.metrics.latency.WithLabelValues(route, r.Method).Observe(elapsed.Seconds())
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case route == "/healthz" || route == "/readyz" || route == "/metrics":
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("ip", s.clientIP(r)),
+		)
+	})
+}
+
+// graphQLOperation names a query for the timing labels by its type alone.
+// Root fields and operation names are chosen by the client, so labelling
+// by them would let any client create new series.
+func graphQLOperation(query, operationName string) string {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return "invalid"
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+		return op.Operation
+	}
+	return "invalid"
+}
+
+// poolCollector reports pgxpool statistics when scraped.
+type poolCollector struct{ pool *pgxpool.Pool }
+
+var (
+	poolAcquiredDesc = prometheus.NewDesc("guestbook_db_pool_acquired_conns", "Connections currently in use.", nil, nil)
+	poolIdleDesc     = prometheus.NewDesc("guestbook_db_pool_idle_conns", "Idle connections.", nil, nil)
+	poolTotalDesc    = prometheus.NewDesc("guestbook_db_pool_total_conns", "Open connections.", nil, nil)
+	poolMaxDesc      = prometheus.NewDesc("guestbook_db_pool_max_conns", "Maximum pool size.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("guestbook_db_pool_acquires_total", "Successful connection acquisitions.", nil, nil)
+	poolEmptyDesc    = prometheus.NewDesc("guestbook_db_pool_empty_acquires_total", "Acquisitions that had to wait for a connection.", nil, nil)
+	poolCanceledDesc = prometheus.NewDesc("guestbook_db_pool_canceled_acquires_total", "Acquisitions canceled before a connection was available.", nil, nil)
+	poolWaitDesc     = prometheus.NewDesc("guestbook_db_pool_acquire_seconds_total", "Time spent acquiring connections.", nil, nil)
+)
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolAcquiredDesc, poolIdleDesc, poolTotalDesc, poolMaxDesc, poolAcquiresDesc, poolEmptyDesc, poolCanceledDesc, poolWaitDesc} {
+		ch <- d
+	}
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredDesc, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyDesc, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledDesc, prometheus.CounterValue, float64(st.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
+
+// storageCollector reports how many bytes of recordings and photos are
+// stored. octet_length reads the stored size without loading the values.
+type storageCollector struct{ pool *pgxpool.Pool }
+
+var storedBytesDesc = prometheus.NewDesc("guestbook_stored_bytes", "Bytes of guest media stored in Postgres, by type.", []string{"type"}, nil)
+
+var storedBytesQueries = []struct{ kind, query string }{
+	{submissionVoice, `SELECT COALESCE(SUM(octet_length(audio) + COALESCE(octet_length(normalized_audio), 0)), 0) FROM voice_messages`},
+	{submissionPhoto, `SELECT COALESCE(SUM(octet_length(image) + octet_length(thumbnail)), 0) FROM photo_messages`},
+	{submissionVideo, `SELECT COALESCE(SUM(octet_length(video)), 0) FROM video_messages`},
+}
+
+func (c storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storedBytesDesc
+}
+
+func (c storageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for _, q := range storedBytesQueries {
+		var n int64
+		if err := c.pool.QueryRow(ctx, q.query).Scan(&n); err != nil {
+			slog.ErrorContext(ctx, "collect stored bytes", "type", q.kind, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(n), q.kind)
+	}
+}
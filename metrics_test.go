@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestGraphQLOperation(t *testing.T) {
+	tests := []struct {
+		query, operationName, want string
+	}{
+		{`{ messages { id } }`, "", "query"},
+		{`query Feed { messages { id } voiceMessages { id } }`, "", "query"},
+		{`mutation { setMessageApproved(id: 1, approved: false) { id } }`, "", "mutation"},
+		{`query A { messages { id } } mutation B { submitMessage(text: "hi") { id } }`, "B", "mutation"},
+		{`query A { messages { id } }`, "Missing", "invalid"},
+		{`{ messages {`, "", "invalid"},
+	}
+	for _, tt := range tests {
+		if got := graphQLOperation(tt.query, tt.operationName); got != tt.want {
+			t.Errorf("graphQLOperation(%q, %q) = %q, want %q", tt.query, tt.operationName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migration is one versioned schema change with the SQL that reverts it.
+// A repeatable migration is applied again whenever its SQL changes, which
+// suits idempotent objects such as views and functions; tables and columns
+// change through new versions instead.
+//
+// Every up is written to be idempotent (IF NOT EXISTS and guarded
+// backfills), so it also brings a database created before migrations were
+// recorded up to date without failing on objects it already has.
+type migration struct {
+	version    int
+	name       string
+	up         string
+	down       string
+	repeatable bool
+}
+
+var migrations = []migration{
+	{version: 1, name: "messages", up: `
+CREATE TABLE IF NOT EXISTS messages (
+  id SERIAL PRIMARY KEY,
+  guest_name TEXT NOT NULL DEFAULT '',
+  text TEXT NOT NULL CHECK (char_length(text) <= 1000),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS voice_messages (
+  id SERIAL PRIMARY KEY,
+  guest_name TEXT NOT NULL DEFAULT '',
+  note TEXT,
+  audio BYTEA NOT NULL,
+  mime_type TEXT NOT NULL,
+  duration_seconds INT NOT NULL CHECK (duration_seconds > 0 AND duration_seconds <= 60),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS guest_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS guest_name TEXT NOT NULL DEFAULT '';`,
+		down: `DROP TABLE IF EXISTS voice_messages, messages`},
+
+	{version: 2, name: "sessions", up: `
+CREATE TABLE IF NOT EXISTS admin_sessions (
+  id TEXT PRIMARY KEY,
+  username TEXT NOT NULL,
+  role TEXT NOT NULL DEFAULT 'admin',
+  csrf_token TEXT NOT NULL,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+ALTER TABLE admin_sessions ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'admin';`,
+		down: `DROP TABLE IF EXISTS admin_sessions`},
+
+	{version: 3, name: "tokens", up: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  prefix TEXT NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);`,
+		down: `DROP TABLE IF EXISTS api_tokens`},
+
+	{version: 4, name: "moderation", up: `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS approved BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS approved BOOLEAN NOT NULL DEFAULT TRUE;`,
+		down: `
+ALTER TABLE messages DROP COLUMN IF EXISTS approved;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS approved;`},
+
+	{version: 5, name: "invites", up: `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS invite_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS invite_kind TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS table_label TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS invite_guest TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS invite_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS invite_kind TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS table_label TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS invite_guest TEXT NOT NULL DEFAULT '';`,
+		down: `
+ALTER TABLE messages DROP COLUMN IF EXISTS invite_id, DROP COLUMN IF EXISTS invite_kind, DROP COLUMN IF EXISTS table_label, DROP COLUMN IF EXISTS invite_guest;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS invite_id, DROP COLUMN IF EXISTS invite_kind, DROP COLUMN IF EXISTS table_label, DROP COLUMN IF EXISTS invite_guest;`},
+
+	{version: 6, name: "guest edits", up: `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edit_token_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS edit_token_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;`,
+		down: `
+ALTER TABLE messages DROP COLUMN IF EXISTS edit_token_hash, DROP COLUMN IF EXISTS edited_at;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS edit_token_hash, DROP COLUMN IF EXISTS edited_at;`},
+
+	{version: 7, name: "media", up: `
+CREATE TABLE IF NOT EXISTS photo_messages (
+  id SERIAL PRIMARY KEY,
+  guest_name TEXT NOT NULL DEFAULT '',
+  caption TEXT NOT NULL DEFAULT '',
+  image BYTEA NOT NULL,
+  mime_type TEXT NOT NULL,
+  width INT NOT NULL,
+  height INT NOT NULL,
+  byte_size INT NOT NULL,
+  thumbnail BYTEA NOT NULL,
+  approved BOOLEAN NOT NULL DEFAULT TRUE,
+  invite_id TEXT NOT NULL DEFAULT '',
+  invite_kind TEXT NOT NULL DEFAULT '',
+  table_label TEXT NOT NULL DEFAULT '',
+  invite_guest TEXT NOT NULL DEFAULT '',
+  edit_token_hash TEXT NOT NULL DEFAULT '',
+  edited_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS video_messages (
+  id SERIAL PRIMARY KEY,
+  guest_name TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  video BYTEA NOT NULL,
+  mime_type TEXT NOT NULL,
+  duration_seconds INT NOT NULL CHECK (duration_seconds > 0),
+  video_codec TEXT NOT NULL,
+  audio_codec TEXT NOT NULL DEFAULT '',
+  width INT NOT NULL DEFAULT 0,
+  height INT NOT NULL DEFAULT 0,
+  byte_size INT NOT NULL,
+  approved BOOLEAN NOT NULL DEFAULT TRUE,
+  invite_id TEXT NOT NULL DEFAULT '',
+  invite_kind TEXT NOT NULL DEFAULT '',
+  table_label TEXT NOT NULL DEFAULT '',
+  invite_guest TEXT NOT NULL DEFAULT '',
+  edit_token_hash TEXT NOT NULL DEFAULT '',
+  edited_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Media is streamed in slices with substring(); uncompressed out-of-line
+-- storage lets Postgres fetch a slice without reading the whole value.
+ALTER TABLE voice_messages ALTER COLUMN audio SET STORAGE EXTERNAL;
+ALTER TABLE video_messages ALTER COLUMN video SET STORAGE EXTERNAL;`,
+		down: `
+DROP TABLE IF EXISTS video_messages, photo_messages;
+ALTER TABLE voice_messages ALTER COLUMN audio SET STORAGE EXTENDED;`},
+
+	{version: 8, name: "entries", up: `
+CREATE TABLE IF NOT EXISTS entries (
+  id SERIAL PRIMARY KEY,
+  guest_name TEXT NOT NULL DEFAULT '',
+  invite_id TEXT NOT NULL DEFAULT '',
+  invite_kind TEXT NOT NULL DEFAULT '',
+  table_label TEXT NOT NULL DEFAULT '',
+  invite_guest TEXT NOT NULL DEFAULT '',
+  edit_token_hash TEXT NOT NULL DEFAULT '',
+  edited_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS entry_id INT REFERENCES entries(id) ON DELETE CASCADE;
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS entry_id INT REFERENCES entries(id) ON DELETE CASCADE;
+ALTER TABLE photo_messages ADD COLUMN IF NOT EXISTS entry_id INT REFERENCES entries(id) ON DELETE CASCADE;
+ALTER TABLE video_messages ADD COLUMN IF NOT EXISTS entry_id INT REFERENCES entries(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS messages_entry_id_idx ON messages (entry_id);
+CREATE INDEX IF NOT EXISTS voice_messages_entry_id_idx ON voice_messages (entry_id);
+CREATE INDEX IF NOT EXISTS photo_messages_entry_id_idx ON photo_messages (entry_id);
+CREATE INDEX IF NOT EXISTS video_messages_entry_id_idx ON video_messages (entry_id);
+
+-- Rows written before entries existed each become an entry of their own.
+DO $$
+DECLARE
+  src TEXT;
+  r RECORD;
+  new_id INT;
+BEGIN
+  FOREACH src IN ARRAY ARRAY['messages', 'voice_messages', 'photo_messages', 'video_messages'] LOOP
+    FOR r IN EXECUTE format('SELECT id, guest_name, invite_id, invite_kind, table_label, invite_guest, created_at FROM %I WHERE entry_id IS NULL', src) LOOP
+      INSERT INTO entries (guest_name, invite_id, invite_kind, table_label, invite_guest, created_at)
+      VALUES (r.guest_name, r.invite_id, r.invite_kind, r.table_label, r.invite_guest, r.created_at)
+      RETURNING id INTO new_id;
+      EXECUTE format('UPDATE %I SET entry_id = $1 WHERE id = $2', src) USING new_id, r.id;
+    END LOOP;
+  END LOOP;
+END $$;`,
+		down: `
+ALTER TABLE messages DROP COLUMN IF EXISTS entry_id;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS entry_id;
+ALTER TABLE photo_messages DROP COLUMN IF EXISTS entry_id;
+ALTER TABLE video_messages DROP COLUMN IF EXISTS entry_id;
+DROP TABLE IF EXISTS entries;`},
+
+	{version: 9, name: "waveforms", up: `
+-- Waveform peaks of voice messages; NULL until computed, empty when the
+-- audio could not be decoded.
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS waveform REAL[];`,
+		down: `ALTER TABLE voice_messages DROP COLUMN IF EXISTS waveform`},
+
+	{version: 10, name: "transcripts", up: `
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcript TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcript_language TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcript_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcript_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcribed_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS voice_messages_transcript_pending_idx ON voice_messages (id) WHERE transcript_status = 'pending';`,
+		down: `
+DROP INDEX IF EXISTS voice_messages_transcript_pending_idx;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS transcript, DROP COLUMN IF EXISTS transcript_language,
+  DROP COLUMN IF EXISTS transcript_status, DROP COLUMN IF EXISTS transcript_error, DROP COLUMN IF EXISTS transcribed_at;`},
+
+	{version: 11, name: "loudness", up: `
+-- Loudness-normalized copy of each voice message, made in the background.
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS normalized_audio BYTEA;
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS normalized_mime_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS loudness_lufs DOUBLE PRECISION;
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS audio_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS audio_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE voice_messages ALTER COLUMN normalized_audio SET STORAGE EXTERNAL;
+CREATE INDEX IF NOT EXISTS voice_messages_audio_pending_idx ON voice_messages (id) WHERE audio_status = 'pending';`,
+		down: `
+DROP INDEX IF EXISTS voice_messages_audio_pending_idx;
+ALTER TABLE voice_messages DROP COLUMN IF EXISTS normalized_audio, DROP COLUMN IF EXISTS normalized_mime_type,
+  DROP COLUMN IF EXISTS loudness_lufs, DROP COLUMN IF EXISTS audio_status, DROP COLUMN IF EXISTS audio_error;`},
+
+	{version: 12, name: "jobs", up: `
+-- Background jobs. Workers claim due queued rows with FOR UPDATE SKIP
+-- LOCKED; locked_until is the lease of a running job.
+CREATE TABLE IF NOT EXISTS jobs (
+  id BIGSERIAL PRIMARY KEY,
+  kind TEXT NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  status TEXT NOT NULL DEFAULT 'queued',
+  attempts INT NOT NULL DEFAULT 0,
+  run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT NOT NULL DEFAULT '',
+  locked_until TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (kind, run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, id);
+
+-- Voice messages stored before the job queue still need their jobs.
+INSERT INTO jobs (kind, payload)
+SELECT 'transcribe_voice', jsonb_build_object('voice_message_id', v.id) FROM voice_messages v
+WHERE v.transcript_status IN ('pending', 'running')
+  AND NOT EXISTS (SELECT 1 FROM jobs j WHERE j.kind = 'transcribe_voice' AND j.status IN ('queued', 'running') AND (j.payload->>'voice_message_id')::int = v.id);
+INSERT INTO jobs (kind, payload)
+SELECT 'normalize_voice', jsonb_build_object('voice_message_id', v.id) FROM voice_messages v
+WHERE v.audio_status IN ('pending', 'running')
+  AND NOT EXISTS (SELECT 1 FROM jobs j WHERE j.kind = 'normalize_voice' AND j.status IN ('queued', 'running') AND (j.payload->>'voice_message_id')::int = v.id);`,
+		down: `DROP TABLE IF EXISTS jobs`},
+
+	{version: 13, name: "limits", up: `
+-- Message length and clip duration are limits in the config now, checked
+-- before insert; the fixed caps from the first schema would reject larger
+-- configured values.
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_text_check;
+ALTER TABLE voice_messages DROP CONSTRAINT IF EXISTS voice_messages_duration_seconds_check,
+  ADD CONSTRAINT voice_messages_duration_seconds_check CHECK (duration_seconds > 0);`,
+		down: `
+-- Rows stored under larger limits stay; only new rows are checked again.
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_text_check,
+  ADD CONSTRAINT messages_text_check CHECK (char_length(text) <= 1000) NOT VALID;
+ALTER TABLE voice_messages DROP CONSTRAINT IF EXISTS voice_messages_duration_seconds_check,
+  ADD CONSTRAINT voice_messages_duration_seconds_check CHECK (duration_seconds > 0 AND duration_seconds <= 60) NOT VALID;`},
+}
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INT PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+// migrationLockID serializes migrations between processes starting at once.
+const migrationLockID = 7263412
+
+func (m migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.up))
+	return hex.EncodeToString(sum[:])
+}
+
+type migrationState struct {
+	migration
+	appliedAt *time.Time
+	// changed is set when the applied SQL differs from this binary's.
+	changed bool
+}
+
+func (s migrationState) pending() bool {
+	return s.appliedAt == nil || (s.changed && s.repeatable)
+}
+
+func migrationStates(ctx context.Context, db pgx.Tx) ([]migrationState, error) {
+	type applied struct {
+		checksum string
+		at       time.Time
+	}
+	rows, err := db.Query(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]applied{}
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.checksum, &a.at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		done[version] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	states := make([]migrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].migration = m
+		if a, ok := done[m.version]; ok {
+			states[i].appliedAt = &a.at
+			states[i].changed = a.checksum != m.checksum()
+		}
+	}
+	return states, nil
+}
+
+// withMigrationLock runs fn in a transaction holding the migration lock,
+// with schema_migrations created.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, migrationsTable); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// migrateUp applies every pending migration in one transaction and
+// returns them.
+func migrateUp(ctx context.Context, pool *pgxpool.Pool) ([]migration, error) {
+	var applied []migration
+	err := withMigrationLock(ctx, pool, func(tx pgx.Tx) error {
+		states, err := migrationStates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			if !st.pending() {
+				continue
+			}
+			if _, err := tx.Exec(ctx, st.up); err != nil {
+				return fmt.Errorf("migration %d %s: %w", st.version, st.name, err)
+			}
+			const record = `
+INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+ON CONFLICT (version) DO UPDATE SET name = EXCLUDED.name, checksum = EXCLUDED.checksum, applied_at = NOW()`
+			if _, err := tx.Exec(ctx, record, st.version, st.name, st.checksum()); err != nil {
+				return err
+			}
+			applied = append(applied, st.migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// migrateDown reverts the latest applied migration.
+func migrateDown(ctx context.Context, pool *pgxpool.Pool) (*migration, error) {
+	var reverted *migration
+	err := withMigrationLock(ctx, pool, func(tx pgx.Tx) error {
+		states, err := migrationStates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for i := len(states) - 1; i >= 0; i-- {
+			st := states[i]
+			if st.appliedAt == nil {
+				continue
+			}
+			if _, err := tx.Exec(ctx, st.down); err != nil {
+				return fmt.Errorf("migration %d %s: %w", st.version, st.name, err)
+			}
+			if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, st.version); err != nil {
+				return err
+			}
+			reverted = &st.migration
+			return nil
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// ensureSchema brings the database up to date when the server starts.
+func ensureSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := migrateUp(ctx, pool)
+	return err
+}
+
+// requireCurrentSchema fails when migrations are pending, for commands
+// that should not change the schema themselves.
+func requireCurrentSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	var pending []string
+	err := withMigrationLock(ctx, pool, func(tx pgx.Tx) error {
+		states, err := migrationStates(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			if st.pending() {
+				pending = append(pending, fmt.Sprintf("%d %s", st.version, st.name))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is out of date (pending: %v); run `migrate up`", pending)
+	}
+	return nil
+}
+
+// runMigrate implements `migrate up|down|status`.
+func runMigrate(ctx context.Context, args []string, stdout io.Writer) error {
+	usage := errors.New("usage: migrate up | migrate down -yes | migrate status")
+	if len(args) == 0 {
+		return usage
+	}
+	sub, args := args[0], args[1:]
+	flags := flag.NewFlagSet("migrate "+sub, flag.ContinueOnError)
+	opts := configFlags(flags)
+	yes := flags.Bool("yes", false, "confirm `migrate down`, which drops tables and their data")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	if sub != "up" && sub != "down" && sub != "status" {
+		return usage
+	}
+	if sub == "down" && !*yes {
+		return errors.New("migrate down drops tables and everything in them; pass -yes to confirm")
+	}
+
+	cfg, err := opts.load()
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to create db pool: %w", err)
+	}
+	defer pool.Close()
+
+	switch sub {
+	case "up":
+		applied, err := migrateUp(ctx, pool)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			fmt.Fprintf(stdout, "applied %d %s\n", m.version, m.name)
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(stdout, "schema is up to date")
+		}
+	case "down":
+		reverted, err := migrateDown(ctx, pool)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			fmt.Fprintln(stdout, "nothing to revert")
+		} else {
+			fmt.Fprintf(stdout, "reverted %d %s\n", reverted.version, reverted.name)
+		}
+	case "status":
+		var states []migrationState
+		if err := withMigrationLock(ctx, pool, func(tx pgx.Tx) error {
+			states, err = migrationStates(ctx, tx)
+			return err
+		}); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED")
+		for _, st := range states {
+			status, at := "pending", ""
+			if st.appliedAt != nil {
+				status, at = "applied", st.appliedAt.Format(time.DateTime)
+				if st.changed {
+					status = "changed"
+				}
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", st.version, st.name, status, at)
+		}
+		return tw.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	names := map[string]bool{}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.name, m.version, i+1)
+		}
+		if names[m.name] {
+			t.Errorf("migration name %q is used twice", m.name)
+		}
+		names[m.name] = true
+		if strings.TrimSpace(m.up) == "" || strings.TrimSpace(m.down) == "" {
+			t.Errorf("migration %d %s needs both an up and a down", m.version, m.name)
+		}
+		// Repeatable migrations are rerun over live data whenever they
+		// change, which only idempotent objects survive.
+		if m.repeatable && strings.Contains(strings.ToUpper(m.up), "TABLE") {
+			t.Errorf("migration %d %s changes tables and cannot be repeatable", m.version, m.name)
+		}
+	}
+}
@@ -1,26 +1,176 @@
-import { useEffect, useState } from 'react'
+import { type FormEvent, useEffect, useState } from 'react'
 import './App.css'
-import { listMessages, listVoiceMessages, type Message, type VoiceMessage } from './lib/api'
+import {
+  archiveUrl,
+  bookUrl,
+  getAuthMethods,
+  getSession,
+  listMessages,
+  listPhotoMessages,
+  listVideoMessages,
+  listVoiceMessages,
+  login,
+  logout,
+  ssoLoginUrl,
+  UnauthorizedError,
+  type AuthMethods,
+  type Message,
+  type Session,
+  type PhotoMessage,
+  type VideoMessage,
+  type VoiceMessage,
+} from './lib/api'
 
 export default function App() {
+  const [session, setSession] = useState<Session | null>(null)
+  const [sessionChecked, setSessionChecked] = useState(false)
+
+  useEffect(() => {
+    getSession()
+      .then(setSession)
+      .catch(() => setSession(null))
+      .finally(() => setSessionChecked(true))
+  }, [])
+
+  if (!sessionChecked) {
+    return (
+      <div className="app">
+        <p className="muted">Checking session…</p>
+      </div>
+    )
+  }
+  if (!session) {
+    return <LoginForm onSignedIn={setSession} />
+  }
+  return <Monitor session={session} onSignedOut={() => setSession(null)} />
+}
+
+function LoginForm({ onSignedIn }: { onSignedIn: (session: Session) => void }) {
+  const [username, setUsername] = useState('')
+  const [password, setPassword] = useState('')
+  const [status, setStatus] = useState<'idle' | 'loading' | 'error'>('idle')
+  const [error, setError] = useState('')
+  const [methods, setMethods] = useState<AuthMethods>({ password: true, oidc: false })
+
+  useEffect(() => {
+    getAuthMethods()
+      .then(setMethods)
+      .catch(() => {
+        /* keep the password form */
+      })
+  }, [])
+
+  const handleSubmit = async (event: FormEvent<HTMLFormElement>) => {
+    event.preventDefault()
+    try {
+      setStatus('loading')
+      setError('')
+      const session = await login(username, password)
+      setPassword('')
+      onSignedIn(session)
+    } catch (err) {
+      setStatus('error')
+      setError(err instanceof Error ? err.message : 'Sign in failed.')
+    }
+  }
+
+  return (
+    <div className="app">
+      <header className="hero">
+        <div>
+          <p className="eyebrow">Admin</p>
+          <h1>Guestbook monitor</h1>
+          <p className="muted">Sign in with the admin credentials configured on the server.</p>
+        </div>
+      </header>
+      {methods.oidc && (
+        <div className="panel login-form">
+          <a className="sso-btn" href={ssoLoginUrl()}>
+            Sign in with single sign-on
+          </a>
+        </div>
+      )}
+      {methods.password && (
+        <form className="panel login-form" onSubmit={handleSubmit}>
+          <label htmlFor="admin-username">Username</label>
+          <input
+            id="admin-username"
+            autoComplete="username"
+            value={username}
+            onChange={(event) => setUsername(event.target.value)}
+            disabled={status === 'loading'}
+            required
+          />
+          <label htmlFor="admin-password">Password</label>
+          <input
+            id="admin-password"
+            type="password"
+            autoComplete="current-password"
+            value={password}
+            onChange={(event) => setPassword(event.target.value)}
+            disabled={status === 'loading'}
+            required
+          />
+          <button type="submit" disabled={status === 'loading'}>
+            {status === 'loading' ? 'Signing in…' : 'Sign in'}
+          </button>
+          {status === 'error' && error && <div className="alert">{error}</div>}
+        </form>
+      )}
+    </div>
+  )
+}
+
+// Waveform draws the server-computed peaks as mirrored bars.
+function Waveform({ peaks }: { peaks: number[] }) {
+  if (peaks.length === 0) return null
+  const loudest = Math.max(...peaks, 0.01)
+  return (
+    <svg className="waveform" viewBox={`0 0 ${peaks.length * 3} 40`} preserveAspectRatio="none" aria-hidden="true">
+      {peaks.map((peak, i) => {
+        const height = Math.max(1, (peak / loudest) * 38)
+        return <rect key={i} x={i * 3} y={20 - height / 2} width={2} height={height} rx={1} />
+      })}
+    </svg>
+  )
+}
+
+function Monitor({ session, onSignedOut }: { session: Session; onSignedOut: () => void }) {
   const [messages, setMessages] = useState<Message[]>([])
   const [voiceMessages, setVoiceMessages] = useState<VoiceMessage[]>([])
+  const [photoMessages, setPhotoMessages] = useState<PhotoMessage[]>([])
+  const [videoMessages, setVideoMessages] = useState<VideoMessage[]>([])
   const [status, setStatus] = useState<'idle' | 'loading' | 'error'>('loading')
   const [error, setError] = useState('')
 
+  const signOut = async () => {
+    await logout().catch(() => {
+      /* cookie is cleared server-side on success; ignore network errors */
+    })
+    onSignedOut()
+  }
+
   const load = async () => {
     const controller = new AbortController()
     const timeout = window.setTimeout(() => controller.abort(), 8000)
     try {
       setStatus('loading')
-      const [text, voice] = await Promise.all([
+      const [text, voice, photos, videos] = await Promise.all([
         listMessages(controller.signal),
         listVoiceMessages(controller.signal),
+        listPhotoMessages(controller.signal),
+        listVideoMessages(controller.signal),
       ])
       setMessages(text)
       setVoiceMessages(voice)
+      setPhotoMessages(photos)
+      setVideoMessages(videos)
       setStatus('idle')
     } catch (err) {
+      if (err instanceof UnauthorizedError) {
+        onSignedOut()
+        return
+      }
       setStatus('error')
       setError(err instanceof Error ? err.message : 'Unable to load data.')
     } finally {
@@ -56,11 +206,12 @@ export default function App() {
 
   const exportVoiceCsv = () => {
     const rows = [
-      ['id', 'guestName', 'note', 'durationSeconds', 'createdAt', 'audioUrl'],
+      ['id', 'guestName', 'note', 'transcript', 'durationSeconds', 'createdAt', 'audioUrl'],
       ...voiceMessages.map((v) => [
         String(v.id),
         v.guestName || '',
         v.note || '',
+        v.transcript || '',
         String(v.durationSeconds),
         v.createdAt,
         v.audioUrl,
@@ -87,6 +238,15 @@ export default function App() {
           <button type="button" onClick={exportVoiceCsv} disabled={voiceMessages.length === 0}>
             Export voice CSV
           </button>
+          <a className="refresh-btn" href={archiveUrl()} download>
+            Download archive
+          </a>
+          <a className="refresh-btn" href={bookUrl()} download>
+            Download book (PDF)
+          </a>
+          <button type="button" onClick={signOut} title={`Signed in as ${session.username}`}>
+            Sign out
+          </button>
         </div>
       </header>
 
@@ -132,6 +292,12 @@ export default function App() {
                   {v.guestName || 'Anonymous'} · {new Date(v.createdAt).toLocaleString()} · {v.durationSeconds}s
                 </p>
                 {v.note && <p className="body">{v.note}</p>}
+                <Waveform peaks={v.waveform} />
+                {v.transcript && (
+                  <p className="body transcript">
+                    “{v.transcript}”{v.transcriptLanguage && ` (${v.transcriptLanguage})`}
+                  </p>
+                )}
                 {v.audioUrl ? (
                   <audio controls preload="none">
                     <source src={v.audioUrl} type={v.mimeType || 'audio/webm'} />
@@ -145,6 +311,60 @@ export default function App() {
             ))}
           </ul>
         </section>
+
+        <section className="panel">
+          <div className="panel-head">
+            <h2>Photos</h2>
+            <p className="muted small">
+              Newest first · capped at 1000 · showing {photoMessages.length}
+            </p>
+          </div>
+          {photoMessages.length === 0 && status !== 'loading' && (
+            <p className="muted">No photos yet.</p>
+          )}
+          <ul className="list">
+            {photoMessages.map((p) => (
+              <li key={p.id} className="card">
+                <p className="meta">
+                  {p.guestName || 'Anonymous'} · {new Date(p.createdAt).toLocaleString()} · {p.width}×{p.height}
+                </p>
+                {p.caption && <p className="body">{p.caption}</p>}
+                {p.thumbnailUrl ? (
+                  <a href={p.imageUrl} target="_blank" rel="noreferrer">
+                    <img className="photo-thumb" src={p.thumbnailUrl} alt={p.caption || `Photo from ${p.guestName}`} />
+                  </a>
+                ) : (
+                  <p className="muted small">Photo unavailable — failed to load.</p>
+                )}
+              </li>
+            ))}
+          </ul>
+        </section>
+
+        <section className="panel">
+          <div className="panel-head">
+            <h2>Videos</h2>
+            <p className="muted small">
+              Newest first · capped at 1000 · showing {videoMessages.length}
+            </p>
+          </div>
+          {videoMessages.length === 0 && status !== 'loading' && (
+            <p className="muted">No videos yet.</p>
+          )}
+          <ul className="list">
+            {videoMessages.map((v) => (
+              <li key={v.id} className="card">
+                <p className="meta">
+                  {v.guestName || 'Anonymous'} · {new Date(v.createdAt).toLocaleString()} · {v.durationSeconds}s
+                </p>
+                {v.note && <p className="body">{v.note}</p>}
+                <video className="photo-thumb" controls preload="metadata" playsInline>
+                  <source src={v.videoUrl} type={v.mimeType} />
+                </video>
+              </li>
+            ))}
+          </ul>
+        </section>
       </div>
     </div>
   )
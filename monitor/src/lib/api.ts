@@ -1,13 +1,33 @@
-const ADMIN_USER = import.meta.env.VITE_ADMIN_USERNAME
-const ADMIN_PASS = import.meta.env.VITE_ADMIN_PASSWORD
-
 function resolveApiBase(): string {
   if (import.meta.env.VITE_API_BASE) return import.meta.env.VITE_API_BASE
-  // Dev fallback: assume Go API on :3000 if monitor is running elsewhere (e.g., :5273)
-  return 'http://localhost:3000'
+  // Dev fallback: same origin, proxied to the Go API by vite.config.ts so the
+  // session cookie stays first-party.
+  return ''
 }
 const API_BASE = resolveApiBase()
 
+// CSRF token for the current admin session. The session itself lives in an
+// HttpOnly cookie, so no credentials are ever stored in the bundle.
+let csrfToken = ''
+
+export type Session = {
+  username: string
+  expiresAt: string
+}
+
+type ApiSession = {
+  username: string
+  csrf_token: string
+  expires_at: string
+}
+
+export class UnauthorizedError extends Error {
+  constructor(message = 'Please sign in') {
+    super(message)
+    this.name = 'UnauthorizedError'
+  }
+}
+
 type ApiMessage = {
   id: number
   guestName: string
@@ -23,6 +43,45 @@ type ApiVoiceMessage = {
   mimeType: string
   createdAt: string
   audioUrl: string
+  waveform: number[] | null
+  transcript: string
+  transcriptLanguage: string
+  transcriptStatus: string
+  audioStatus: string
+}
+
+type ApiPhotoMessage = {
+  id: number
+  guestName: string
+  caption: string
+  width: number
+  height: number
+  createdAt: string
+  imageUrl: string
+  thumbnailUrl: string
+}
+
+export type PhotoMessage = {
+  id: number
+  guestName: string
+  caption: string
+  width: number
+  height: number
+  createdAt: string
+  imageUrl: string
+  thumbnailUrl: string
+}
+
+export type VideoMessage = {
+  id: number
+  guestName: string
+  note: string
+  durationSeconds: number
+  mimeType: string
+  width: number
+  height: number
+  createdAt: string
+  videoUrl: string
 }
 
 export type Message = {
@@ -40,6 +99,11 @@ export type VoiceMessage = {
   createdAt: string
   audioUrl: string
   mimeType: string
+  // Peaks between 0 and 1 computed by the server; empty when unavailable.
+  waveform: number[]
+  transcript: string
+  transcriptLanguage: string
+  transcriptStatus: string
 }
 
 function withApiBase(path: string) {
@@ -47,11 +111,88 @@ function withApiBase(path: string) {
   return `${API_BASE}${path}`
 }
 
-async function fetchVoiceAudio(id: number): Promise<string> {
-  const response = await fetch(withApiBase(`/voice-messages/${id}/audio`), {
+function applySession(payload: ApiSession): Session {
+  csrfToken = payload.csrf_token
+  return { username: payload.username, expiresAt: payload.expires_at }
+}
+
+export type AuthMethods = {
+  password: boolean
+  oidc: boolean
+}
+
+export async function getAuthMethods(): Promise<AuthMethods> {
+  const response = await fetch(withApiBase('/admin/auth-methods'), {
+    headers: { Accept: 'application/json' },
+  })
+  if (!response.ok) return { password: true, oidc: false }
+  return (await response.json()) as AuthMethods
+}
+
+// The archive streams from the server with the session cookie, so it is a
+// plain link rather than a fetch into memory.
+export function archiveUrl(): string {
+  return withApiBase('/admin/archive.zip')
+}
+
+export function bookUrl(): string {
+  return withApiBase('/admin/book.pdf')
+}
+
+// Full-page navigation into the OIDC flow; the server redirects back to
+// OIDC_POST_LOGIN_REDIRECT with the session cookie set.
+export function ssoLoginUrl(): string {
+  return withApiBase('/admin/oidc/login')
+}
+
+export async function getSession(): Promise<Session | null> {
+  const response = await fetch(withApiBase('/admin/session'), {
+    credentials: 'include',
+    headers: { Accept: 'application/json' },
+  })
+  if (response.status === 401) {
+    csrfToken = ''
+    return null
+  }
+  if (!response.ok) {
+    const text = await response.text().catch(() => '')
+    throw new Error(text || 'Failed to load session')
+  }
+  return applySession((await response.json()) as ApiSession)
+}
+
+export async function login(username: string, password: string): Promise<Session> {
+  const response = await fetch(withApiBase('/admin/login'), {
+    method: 'POST',
+    credentials: 'include',
     headers: {
-      ...adminAuthHeader(),
+      'Content-Type': 'application/json',
+      Accept: 'application/json',
     },
+    body: JSON.stringify({ username, password }),
+  })
+  if (!response.ok) {
+    const text = await response.text().catch(() => '')
+    throw new Error(text.trim() || 'Sign in failed')
+  }
+  return applySession((await response.json()) as ApiSession)
+}
+
+export async function logout(): Promise<void> {
+  await fetch(withApiBase('/admin/logout'), {
+    method: 'POST',
+    credentials: 'include',
+    headers: csrfHeader(),
+  })
+  csrfToken = ''
+}
+
+// The normalized variant is a level-matched WAV that plays in every
+// browser; it exists once the server's background worker has made it.
+async function fetchVoiceAudio(id: number, normalized: boolean): Promise<string> {
+  const variant = normalized ? '?variant=normalized' : ''
+  const response = await fetch(withApiBase(`/voice-messages/${id}/audio${variant}`), {
+    credentials: 'include',
     mode: 'cors',
   })
   if (!response.ok) {
@@ -63,10 +204,23 @@ async function fetchVoiceAudio(id: number): Promise<string> {
   return bufferToDataUrl(buffer, mime)
 }
 
-function adminAuthHeader(): Record<string, string> {
-  if (!ADMIN_USER || !ADMIN_PASS) return {}
-  const token = btoa(`${ADMIN_USER}:${ADMIN_PASS}`)
-  return { Authorization: `Basic ${token}` }
+async function fetchPhotoThumbnail(id: number): Promise<string> {
+  const response = await fetch(withApiBase(`/photo-messages/${id}/thumbnail`), {
+    credentials: 'include',
+    mode: 'cors',
+  })
+  if (!response.ok) {
+    const text = await response.text().catch(() => '')
+    throw new Error(text || 'Failed to load photo')
+  }
+  const mime = response.headers.get('Content-Type') ?? 'image/jpeg'
+  const buffer = await response.arrayBuffer()
+  return bufferToDataUrl(buffer, mime)
+}
+
+function csrfHeader(): Record<string, string> {
+  if (!csrfToken) return {}
+  return { 'X-CSRF-Token': csrfToken }
 }
 
 type GraphQLResponse<T> = {
@@ -85,15 +239,19 @@ function bufferToDataUrl(buffer: ArrayBuffer, mime: string): string {
 }
 
 async function graphQLFetch<T>(query: string, variables?: Record<string, unknown>): Promise<T> {
-  const response = await fetch(`${API_BASE}/graphql`, {
+  const response = await fetch(withApiBase('/graphql'), {
     method: 'POST',
+    credentials: 'include',
     headers: {
       'Content-Type': 'application/json',
       Accept: 'application/json',
-      ...adminAuthHeader(),
+      ...csrfHeader(),
     },
     body: JSON.stringify({ query, variables }),
   })
+  if (response.status === 401) {
+    throw new UnauthorizedError()
+  }
   const payload = (await response.json()) as GraphQLResponse<T>
   if (payload.errors && payload.errors.length > 0) {
     throw new Error(payload.errors[0].message || 'Request failed')
@@ -138,6 +296,11 @@ export async function listVoiceMessages(signal?: AbortSignal): Promise<VoiceMess
           mimeType
           createdAt
           audioUrl
+          waveform
+          transcript
+          transcriptLanguage
+          transcriptStatus
+          audioStatus
         }
       }
     `,
@@ -146,7 +309,7 @@ export async function listVoiceMessages(signal?: AbortSignal): Promise<VoiceMess
   const withAudio = await Promise.all(
     data.voiceMessages.map(async (item) => {
       try {
-        const audioUrl = await fetchVoiceAudio(item.id)
+        const audioUrl = await fetchVoiceAudio(item.id, item.audioStatus === 'done')
         return {
           id: item.id,
           guestName: item.guestName,
@@ -154,7 +317,11 @@ export async function listVoiceMessages(signal?: AbortSignal): Promise<VoiceMess
           durationSeconds: item.durationSeconds,
           createdAt: item.createdAt,
           audioUrl,
-          mimeType: item.mimeType || 'audio/webm',
+          mimeType: item.audioStatus === 'done' ? 'audio/wav' : item.mimeType || 'audio/webm',
+          waveform: item.waveform ?? [],
+          transcript: item.transcript,
+          transcriptLanguage: item.transcriptLanguage,
+          transcriptStatus: item.transcriptStatus,
         }
       } catch (err) {
         return {
@@ -165,9 +332,63 @@ export async function listVoiceMessages(signal?: AbortSignal): Promise<VoiceMess
           createdAt: item.createdAt,
           audioUrl: '',
           mimeType: item.mimeType || 'audio/webm',
+          waveform: item.waveform ?? [],
+          transcript: item.transcript,
+          transcriptLanguage: item.transcriptLanguage,
+          transcriptStatus: item.transcriptStatus,
         }
       }
     }),
   )
   return withAudio
 }
+
+export async function listPhotoMessages(signal?: AbortSignal): Promise<PhotoMessage[]> {
+  const data = await graphQLFetch<{ photoMessages: ApiPhotoMessage[] }>(
+    `
+      query PhotoMessages($limit: Int) {
+        photoMessages(limit: $limit) {
+          id
+          guestName
+          caption
+          width
+          height
+          createdAt
+          imageUrl
+          thumbnailUrl
+        }
+      }
+    `,
+    { limit: 1000 },
+  )
+  return Promise.all(
+    data.photoMessages.map(async (item) => {
+      const thumbnailUrl = await fetchPhotoThumbnail(item.id).catch(() => '')
+      return { ...item, imageUrl: withApiBase(item.imageUrl), thumbnailUrl }
+    }),
+  )
+}
+
+export async function listVideoMessages(signal?: AbortSignal): Promise<VideoMessage[]> {
+  const data = await graphQLFetch<{ videoMessages: VideoMessage[] }>(
+    `
+      query VideoMessages($limit: Int) {
+        videoMessages(limit: $limit) {
+          id
+          guestName
+          note
+          durationSeconds
+          mimeType
+          width
+          height
+          createdAt
+          videoUrl
+        }
+      }
+    `,
+    { limit: 1000 },
+  )
+  // Videos stream straight from the server (with Range requests) rather than
+  // being inlined like audio, so only the URL needs the API base.
+  return data.videoMessages.map((item) => ({ ...item, videoUrl: withApiBase(item.videoUrl) }))
+}
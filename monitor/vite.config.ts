@@ -1,6 +1,20 @@
-import { defineConfig } from 'vite'
+import { defineConfig, loadEnv } from 'vite'
 import react from '@vitejs/plugin-react'
 
-export default defineConfig({
-  plugins: [react()],
+export default defineConfig(({ mode }) => {
+  const env = loadEnv(mode, process.cwd(), '')
+  const target = env.VITE_API_PROXY || 'http://localhost:3000'
+  const proxied = { target, changeOrigin: true }
+  return {
+    plugins: [react()],
+    server: {
+      proxy: {
+        '/graphql': proxied,
+        '/admin': proxied,
+        '/voice-messages': proxied,
+        '/photo-messages': proxied,
+        '/video-messages': proxied,
+      },
+    },
+  }
 })
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var errMP4Invalid = errors.New("not a valid MP4 file")
+
+type mp4Track struct {
+	id              uint32
+	handler         string // "vide" or "soun"
+	codec           string // sample entry fourcc, e.g. "avc1"
+	timescale       uint32
+	width           int
+	height          int
+	defaultDuration uint32 // from trex, for fragmented files
+	fragmentEnd     uint64 // in timescale units, summed from moof boxes
+}
+
+type mp4File struct {
+	majorBrand string
+	timescale  uint32
+	duration   uint64 // mvhd duration in timescale units
+	fragmented uint64 // mehd fragment_duration, also in mvhd timescale
+	tracks     []*mp4Track
+}
+
+func (f *mp4File) track(handler string) *mp4Track {
+	for _, t := range f.tracks {
+		if t.handler == handler {
+			return t
+		}
+	}
+	return nil
+}
+
+func (f *mp4File) trackByID(id uint32) *mp4Track {
+	for _, t := range f.tracks {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// totalDuration prefers the movie header, then the fragment header, then
+// the sample durations of the video track. Recorders that write fragmented
+// MP4 (Safari's MediaRecorder) leave the first two at zero.
+func (f *mp4File) totalDuration() time.Duration {
+	if f.timescale > 0 && f.duration > 0 {
+		return scaleDuration(f.duration, f.timescale)
+	}
+	if f.timescale > 0 && f.fragmented > 0 {
+		return scaleDuration(f.fragmented, f.timescale)
+	}
+	var longest time.Duration
+	for _, t := range f.tracks {
+		if t.timescale > 0 {
+			longest = max(longest, scaleDuration(t.fragmentEnd, t.timescale))
+		}
+	}
+	return longest
+}
+
+func scaleDuration(units uint64, timescale uint32) time.Duration {
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second))
+}
+
+// eachMP4Box calls fn for every box in b with its type and payload.
+func eachMP4Box(b []byte, fn func(typ string, body []byte) error) error {
+	for pos := 0; pos < len(b); {
+		if len(b)-pos < 8 {
+			return errMP4Invalid
+		}
+		size := uint64(binary.BigEndian.Uint32(b[pos:]))
+		typ := string(b[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(b) - pos)
+		case 1:
+			if len(b)-pos < 16 {
+				return errMP4Invalid
+			}
+			size = binary.BigEndian.Uint64(b[pos+8:])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(len(b)-pos) {
+			return errMP4Invalid
+		}
+		if err := fn(typ, b[pos+header:pos+int(size)]); err != nil {
+			return err
+		}
+		pos += int(size)
+	}
+	return nil
+}
+
+// parseMP4 reads the brand, tracks and duration of an ISO BMFF file held in
+// memory. Only the boxes needed for that are interpreted.
+func parseMP4(data []byte) (*mp4File, error) {
+	f := &mp4File{}
+	var fragments [][]byte
+	sawMoov := false
+	err := eachMP4Box(data, func(typ string, body []byte) error {
+		switch typ {
+		case "ftyp":
+			if len(body) < 4 {
+				return errMP4Invalid
+			}
+			f.majorBrand = string(body[:4])
+		case "moov":
+			sawMoov = true
+			return f.parseMoov(body)
+		case "moof":
+			fragments = append(fragments, body)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.majorBrand == "" || !sawMoov {
+		return nil, errMP4Invalid
+	}
+	for _, moof := range fragments {
+		if err := f.parseMoof(moof); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *mp4File) parseMoov(moov []byte) error {
+	return eachMP4Box(moov, func(typ string, body []byte) error {
+		switch typ {
+		case "mvhd":
+			f.timescale, f.duration = parseMP4TimeHeader(body)
+		case "trak":
+			t := &mp4Track{}
+			if err := parseMP4Trak(t, body); err != nil {
+				return err
+			}
+			f.tracks = append(f.tracks, t)
+		case "mvex":
+			return eachMP4Box(body, func(typ string, b []byte) error {
+				switch {
+				case typ == "mehd" && len(b) >= 8:
+					if b[0] == 1 && len(b) >= 12 {
+						f.fragmented = binary.BigEndian.Uint64(b[4:])
+					} else {
+						f.fragmented = uint64(binary.BigEndian.Uint32(b[4:]))
+					}
+				case typ == "trex" && len(b) >= 16:
+					if t := f.trackByID(binary.BigEndian.Uint32(b[4:])); t != nil {
+						t.defaultDuration = binary.BigEndian.Uint32(b[12:])
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// parseMP4TimeHeader reads timescale and duration from an mvhd or mdhd box,
+// whose layouts agree up to those fields.
+func parseMP4TimeHeader(b []byte) (uint32, uint64) {
+	if len(b) >= 32 && b[0] == 1 {
+		return binary.BigEndian.Uint32(b[20:]), binary.BigEndian.Uint64(b[24:])
+	}
+	if len(b) >= 20 {
+		return binary.BigEndian.Uint32(b[12:]), uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	return 0, 0
+}
+
+func parseMP4Trak(t *mp4Track, trak []byte) error {
+	return eachMP4Box(trak, func(typ string, body []byte) error {
+		switch typ {
+		case "tkhd":
+			if len(body) >= 24 && body[0] == 1 {
+				t.id = binary.BigEndian.Uint32(body[20:])
+			} else if len(body) >= 16 {
+				t.id = binary.BigEndian.Uint32(body[12:])
+			}
+		case "mdia", "minf", "stbl":
+			return parseMP4Trak(t, body)
+		case "mdhd":
+			t.timescale, _ = parseMP4TimeHeader(body)
+		case "hdlr":
+			if len(body) >= 12 {
+				t.handler = string(body[8:12])
+			}
+		case "stsd":
+			// version/flags, entry count, then the first sample entry box.
+			if len(body) < 16 {
+				return errMP4Invalid
+			}
+			entry := body[8:]
+			t.codec = string(entry[4:8])
+			// A visual sample entry stores width and height after 24 bytes
+			// of reserved and pre-defined fields.
+			if t.handler == "vide" && len(entry) >= 8+28 {
+				t.width = int(binary.BigEndian.Uint16(entry[8+24:]))
+				t.height = int(binary.BigEndian.Uint16(entry[8+26:]))
+			}
+		}
+		return nil
+	})
+}
+
+func (f *mp4File) parseMoof(moof []byte) error {
+	return eachMP4Box(moof, func(typ string, traf []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+		var t *mp4Track
+		var defaultDuration uint32
+		var base uint64
+		hasBase := false
+		var sum uint64
+		err := eachMP4Box(traf, func(typ string, b []byte) error {
+			if len(b) < 8 {
+				return errMP4Invalid
+			}
+			flags := binary.BigEndian.Uint32(b[:4]) & 0xFFFFFF
+			switch typ {
+			case "tfhd":
+				t = f.trackByID(binary.BigEndian.Uint32(b[4:]))
+				if t == nil {
+					return nil
+				}
+				defaultDuration = t.defaultDuration
+				off := 8
+				if flags&0x01 != 0 {
+					off += 8
+				}
+				if flags&0x02 != 0 {
+					off += 4
+				}
+				if flags&0x08 != 0 && len(b) >= off+4 {
+					defaultDuration = binary.BigEndian.Uint32(b[off:])
+				}
+			case "tfdt":
+				hasBase = true
+				if b[0] == 1 && len(b) >= 12 {
+					base = binary.BigEndian.Uint64(b[4:])
+				} else {
+					base = uint64(binary.BigEndian.Uint32(b[4:]))
+				}
+			case "trun":
+				count := int(binary.BigEndian.Uint32(b[4:]))
+				off := 8
+				if flags&0x01 != 0 {
+					off += 4
+				}
+				if flags&0x04 != 0 {
+					off += 4
+				}
+				if flags&0x100 == 0 {
+					sum += uint64(count) * uint64(defaultDuration)
+					return nil
+				}
+				stride := 4
+				for _, bit := range []uint32{0x200, 0x400, 0x800} {
+					if flags&bit != 0 {
+						stride += 4
+					}
+				}
+				if count < 0 || off+count*stride > len(b) {
+					return errMP4Invalid
+				}
+				for i := 0; i < count; i++ {
+					sum += uint64(binary.BigEndian.Uint32(b[off+i*stride:]))
+				}
+			}
+			return nil
+		})
+		if err != nil || t == nil {
+			return err
+		}
+		if !hasBase {
+			base = t.fragmentEnd
+		}
+		t.fragmentEnd = max(t.fragmentEnd, base+sum)
+		return nil
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// mp4Box builds an ISO BMFF box from its type and payload parts.
+func mp4Box(typ string, parts ...[]byte) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32s(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// mp4Trak builds a track with a single sample entry. Video entries carry
+// width and height at their fixed offsets.
+func mp4Trak(id uint32, handler, codec string, timescale uint32, width, height uint16) []byte {
+	entry := make([]byte, 8+28)
+	binary.BigEndian.PutUint16(entry[32:], width)
+	binary.BigEndian.PutUint16(entry[34:], height)
+	return mp4Box("trak",
+		mp4Box("tkhd", u32s(0, 0, 0, id, 0)),
+		mp4Box("mdia",
+			mp4Box("mdhd", u32s(0, 0, 0, timescale, 0)),
+			mp4Box("hdlr", u32s(0, 0), []byte(handler), make([]byte, 12)),
+			mp4Box("minf", mp4Box("stbl", mp4Box("stsd", u32s(0, 1), mp4Box(codec, entry[8:]))))),
+	)
+}
+
+func mp4Fixture() []byte {
+	return append(mp4Box("ftyp", []byte("isom"), u32s(0x200), []byte("isomavc1")),
+		mp4Box("moov",
+			mp4Box("mvhd", u32s(0, 0, 0, 1000, 4500)),
+			mp4Trak(1, "vide", "avc1", 90000, 640, 480),
+			mp4Trak(2, "soun", "mp4a", 48000, 0, 0),
+		)...)
+}
+
+// fragmentedMP4Fixture looks like Safari's MediaRecorder output: no
+// duration in mvhd, a trex default duration and two fragments, the second
+// with explicit per-sample durations.
+func fragmentedMP4Fixture() []byte {
+	var b []byte
+	b = append(b, mp4Box("ftyp", []byte("iso5"), u32s(0x200), []byte("iso5iso6mp41"))...)
+	b = append(b, mp4Box("moov",
+		mp4Box("mvhd", u32s(0, 0, 0, 1000, 0)),
+		mp4Trak(1, "vide", "hvc1", 600, 1080, 1920),
+		mp4Box("mvex", mp4Box("trex", u32s(0, 1, 1, 20, 0, 0))),
+	)...)
+	b = append(b, mp4Box("moof", mp4Box("traf",
+		mp4Box("tfhd", u32s(0, 1)),
+		mp4Box("tfdt", u32s(0, 0)),
+		mp4Box("trun", u32s(0, 30)),
+	))...)
+	b = append(b, mp4Box("mdat", make([]byte, 16))...)
+	b = append(b, mp4Box("moof", mp4Box("traf",
+		mp4Box("tfhd", u32s(0, 1)),
+		mp4Box("tfdt", u32s(0, 600)),
+		mp4Box("trun", u32s(0x100, 2, 30, 270)),
+	))...)
+	return b
+}
+
+func TestParseMP4(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		brand         string
+		duration      time.Duration
+		codec         string
+		width, height int
+		audioCodec    string
+	}{
+		{"progressive", mp4Fixture(), "isom", 4500 * time.Millisecond, "avc1", 640, 480, "mp4a"},
+		{"fragmented", fragmentedMP4Fixture(), "iso5", 1500 * time.Millisecond, "hvc1", 1080, 1920, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseMP4(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.majorBrand != tt.brand {
+				t.Errorf("brand = %q, want %q", f.majorBrand, tt.brand)
+			}
+			if d := f.totalDuration(); d != tt.duration {
+				t.Errorf("duration = %v, want %v", d, tt.duration)
+			}
+			v := f.track("vide")
+			if v == nil {
+				t.Fatal("no video track")
+			}
+			if v.codec != tt.codec || v.width != tt.width || v.height != tt.height {
+				t.Errorf("video = %s %dx%d, want %s %dx%d", v.codec, v.width, v.height, tt.codec, tt.width, tt.height)
+			}
+			audio := ""
+			if a := f.track("soun"); a != nil {
+				audio = a.codec
+			}
+			if audio != tt.audioCodec {
+				t.Errorf("audio codec = %q, want %q", audio, tt.audioCodec)
+			}
+		})
+	}
+}
+
+func TestParseMP4Invalid(t *testing.T) {
+	full := mp4Fixture()
+	tests := map[string][]byte{
+		"empty":        nil,
+		"truncated":    full[:len(full)-10],
+		"no moov":      mp4Box("ftyp", []byte("isom")),
+		"box too long": append(u32s(1000), "ftypisom"...),
+		"short stsd":   append(mp4Box("ftyp", []byte("isom")), mp4Box("moov", mp4Box("trak", mp4Box("stsd", u32s(0))))...),
+		"short trun": append(fragmentedMP4Fixture(), mp4Box("moof", mp4Box("traf",
+			mp4Box("tfhd", u32s(0, 1)), mp4Box("trun", u32s(0x100, 1000, 30))))...),
+	}
+	for name, data := range tests {
+		if _, err := parseMP4(data); err == nil {
+			t.Errorf("%s: parseMP4 accepted it", name)
+		}
+	}
+}
+
+func FuzzParseMP4(f *testing.F) {
+	f.Add(mp4Fixture())
+	f.Add(fragmentedMP4Fixture())
+	f.Add(append(u32s(1), "ftyp"...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mp4, err := parseMP4(data)
+		if err != nil {
+			return
+		}
+		mp4.totalDuration()
+		probeVideo(data)
+	})
+}
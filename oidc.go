@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Admin roles. Password and Basic auth logins are always roleAdmin; SSO users
+// get whichever role their email or group maps to.
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+	roleViewer    = "viewer"
+)
+
+var roleScopes = map[string][]string{
+	roleAdmin:     allScopes,
+	roleModerator: {scopeReadMessages, scopeReadAudio, scopeReadPhotos, scopeReadVideo, scopeModerate},
+	roleViewer:    {scopeReadMessages, scopeReadAudio, scopeReadPhotos, scopeReadVideo},
+}
+
+// roleRank orders roles so the most privileged mapping wins when a user
+// matches several entries.
+var roleRank = map[string]int{roleViewer: 1, roleModerator: 2, roleAdmin: 3}
+
+const (
+	oidcStateCookieName = "guestbook_oidc"
+	oidcStateTTL        = 10 * time.Minute
+)
+
+type oidcConfig struct {
+	issuerURL     string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	postLoginURL  string
+	scopes        []string
+	groupsClaim   string
+	allowedEmails map[string]string // lower-cased email -> role
+	allowedGroups map[string]string // group -> role
+}
+
+// oidcAuth performs the authorization code + PKCE flow against a discovered
+// provider. Discovery is retried lazily so the server can boot while the
+// issuer is unreachable.
+type oidcAuth struct {
+	cfg oidcConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    *oauth2.Config
+}
+
+// oidcConfigFrom returns nil when SSO is not configured.
+func oidcConfigFrom(c *config) (*oidcConfig, error) {
+	issuer := strings.TrimSpace(c.OIDC.IssuerURL)
+	clientID := strings.TrimSpace(c.OIDC.ClientID)
+	if issuer == "" && clientID == "" {
+		return nil, nil
+	}
+	if issuer == "" || clientID == "" {
+		return nil, fmt.Errorf("%s and %s must be set together", setting("OIDC_ISSUER_URL"), setting("OIDC_CLIENT_ID"))
+	}
+	cfg := &oidcConfig{
+		issuerURL:    issuer,
+		clientID:     clientID,
+		clientSecret: c.OIDC.ClientSecret,
+		redirectURL:  c.OIDC.RedirectURL,
+		postLoginURL: c.OIDC.PostLoginRedirect,
+		scopes:       strings.Fields(c.OIDC.Scopes),
+		groupsClaim:  c.OIDC.GroupsClaim,
+	}
+	if cfg.redirectURL == "" {
+		return nil, fmt.Errorf("%s is required (e.g. http://localhost:3000/admin/oidc/callback)", setting("OIDC_REDIRECT_URL"))
+	}
+	if !slices.Contains(cfg.scopes, oidc.ScopeOpenID) {
+		cfg.scopes = append([]string{oidc.ScopeOpenID}, cfg.scopes...)
+	}
+	var err error
+	if cfg.allowedEmails, err = parseRoleMap(c.OIDC.AllowedEmails, true); err != nil {
+		return nil, fmt.Errorf("%s: %w", setting("OIDC_ALLOWED_EMAILS"), err)
+	}
+	if cfg.allowedGroups, err = parseRoleMap(c.OIDC.AllowedGroups, false); err != nil {
+		return nil, fmt.Errorf("%s: %w", setting("OIDC_ALLOWED_GROUPS"), err)
+	}
+	if len(cfg.allowedEmails) == 0 && len(cfg.allowedGroups) == 0 {
+		return nil, fmt.Errorf("set %s or %s; SSO never admits everyone", setting("OIDC_ALLOWED_EMAILS"), setting("OIDC_ALLOWED_GROUPS"))
+	}
+	return cfg, nil
+}
+
+// parseRoleMap reads "name=role,other" lists. Entries without a role map to
+// roleAdmin.
+func parseRoleMap(raw string, lower bool) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, role, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		role = strings.TrimSpace(role)
+		if !ok {
+			role = roleAdmin
+		}
+		if _, known := roleScopes[role]; !known {
+			return nil, fmt.Errorf("unknown role %q for %q", role, name)
+		}
+		if lower {
+			name = strings.ToLower(name)
+		}
+		out[name] = role
+	}
+	return out, nil
+}
+
+func newOIDCAuth(cfg oidcConfig) *oidcAuth {
+	return &oidcAuth{cfg: cfg}
+}
+
+func (o *oidcAuth) init(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.oauth, o.verifier, nil
+	}
+	// The provider keeps this context for fetching JWKS later, so it must
+	// outlive the request that triggered discovery.
+	discoveryCtx := context.WithoutCancel(ctx)
+	provider, err := oidc.NewProvider(discoveryCtx, o.cfg.issuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	o.provider = provider
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.cfg.clientID})
+	o.oauth = &oauth2.Config{
+		ClientID:     o.cfg.clientID,
+		ClientSecret: o.cfg.clientSecret,
+		RedirectURL:  o.cfg.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.cfg.scopes,
+	}
+	return o.oauth, o.verifier, nil
+}
+
+type oidcLoginState struct {
+	State     string    `json:"s"`
+	Nonce     string    `json:"n"`
+	Verifier  string    `json:"v"`
+	ExpiresAt time.Time `json:"e"`
+}
+
+// handleOIDCLogin starts the SSO flow by redirecting to the provider.
+func (s *server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	oauthCfg, _, err := s.oidc.init(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc login", "err", err)
+		clientError(w, r, "single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+	state, err1 := randomToken(24)
+	nonce, err2 := randomToken(24)
+	if err := errors.Join(err1, err2); err != nil {
+		slog.ErrorContext(r.Context(), "oidc login", "err", err)
+		serverError(w, r, "failed to start sign-in")
+		return
+	}
+	ls := oidcLoginState{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	}
+	cookie, err := s.oidcStateCookie(r, ls)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc login", "err", err)
+		serverError(w, r, "failed to start sign-in")
+		return
+	}
+	http.SetCookie(w, cookie)
+	target := oauthCfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(ls.Verifier))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback completes the flow: it exchanges the code using the PKCE
+// verifier, verifies the ID token against the provider's JWKS, maps the user
+// to a role and opens a normal admin session.
+func (s *server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ls, ok := s.oidcStateFromRequest(r)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Path: "/admin/oidc", MaxAge: -1, HttpOnly: true})
+	if !ok || r.URL.Query().Get("state") == "" ||
+		!hmac.Equal([]byte(ls.State), []byte(r.URL.Query().Get("state"))) {
+		clientError(w, r, "sign-in expired or was tampered with, please try again", http.StatusBadRequest)
+		return
+	}
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		slog.WarnContext(r.Context(), "oidc callback: provider returned an error", "error", errCode, "description", r.URL.Query().Get("error_description"))
+		clientError(w, r, "sign-in was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	oauthCfg, verifier, err := s.oidc.init(ctx)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc callback", "err", err)
+		clientError(w, r, "single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+	token, err := oauthCfg.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(ls.Verifier))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc callback: exchange code", "err", err)
+		clientError(w, r, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		clientError(w, r, "sign-in failed: provider returned no id_token", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "oidc callback: verify id token", "err", err)
+		clientError(w, r, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(ls.Nonce)) {
+		clientError(w, r, "sign-in failed: nonce mismatch", http.StatusUnauthorized)
+		return
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		slog.ErrorContext(r.Context(), "oidc callback: decode claims", "err", err)
+		clientError(w, r, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	subject, role := s.oidc.cfg.roleForClaims(claims)
+	if role == "" {
+		slog.WarnContext(r.Context(), "ALERT: oidc sign-in denied: not in allow-list", "subject", idToken.Subject, "email", subject, "ip", s.clientIP(r))
+		clientError(w, r, "your account is not allowed to access the admin area", http.StatusForbidden)
+		return
+	}
+
+	sess, rawID, err := s.createSession(ctx, subject, role, s.clientIP(r), r.UserAgent())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "create admin session", "err", err)
+		serverError(w, r, "failed to start session")
+		return
+	}
+	s.setSessionCookie(w, r, rawID, sess.ExpiresAt)
+	http.Redirect(w, r, s.oidc.cfg.postLoginURL, http.StatusFound)
+}
+
+// roleForClaims returns the display subject and the highest role granted by
+// the email or group allow-lists, or an empty role when neither matches.
+// Unverified emails are ignored.
+func (c *oidcConfig) roleForClaims(claims map[string]any) (string, string) {
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if verified, present := claims["email_verified"].(bool); present && !verified {
+		email = ""
+	}
+	subject := email
+	if subject == "" {
+		subject, _ = claims["sub"].(string)
+	}
+
+	best := ""
+	consider := func(role string) {
+		if roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	if email != "" {
+		if role, ok := c.allowedEmails[email]; ok {
+			consider(role)
+		}
+	}
+	var groups []string
+	switch v := claims[c.groupsClaim].(type) {
+	case []any:
+		for _, g := range v {
+			if name, ok := g.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	case string:
+		groups = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	for _, g := range groups {
+		if role, ok := c.allowedGroups[g]; ok {
+			consider(role)
+		}
+	}
+	return subject, best
+}
+
+// oidcStateCookie carries the login state through the provider round trip,
+// signed so the callback can trust it.
+func (s *server) oidcStateCookie(r *http.Request, ls oidcLoginState) (*http.Cookie, error) {
+	raw, err := json.Marshal(ls)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    s.signValue(base64.RawURLEncoding.EncodeToString(raw)),
+		Path:     "/admin/oidc",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   s.cookieSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+func (s *server) oidcStateFromRequest(r *http.Request) (oidcLoginState, bool) {
+	var ls oidcLoginState
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		return ls, false
+	}
+	payload, ok := s.verifySignedValue(cookie.Value)
+	if !ok {
+		return ls, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(raw, &ls) != nil {
+		return ls, false
+	}
+	return ls, time.Now().Before(ls.ExpiresAt)
+}
+
+// handleAuthMethods tells the monitor which sign-in options to offer.
+func (s *server) handleAuthMethods(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{
+		"password": s.passwordAuthEnabled(),
+		"oidc":     s.oidc != nil,
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRoleMap(t *testing.T) {
+	tests := []struct {
+		raw     string
+		lower   bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{raw: "", want: map[string]string{}},
+		{raw: "Anna@Example.com", lower: true, want: map[string]string{"anna@example.com": roleAdmin}},
+		{raw: " a@x.io = viewer , b@x.io=moderator,,", lower: true, want: map[string]string{"a@x.io": roleViewer, "b@x.io": roleModerator}},
+		{raw: "Wedding-Admins,helpers=viewer", want: map[string]string{"Wedding-Admins": roleAdmin, "helpers": roleViewer}},
+		{raw: "a@x.io=owner", lower: true, wantErr: true},
+		{raw: "helpers=", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRoleMap(tt.raw, tt.lower)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRoleMap(%q) = %v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRoleMap(%q): %v", tt.raw, err)
+			continue
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("parseRoleMap(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRoleForClaims(t *testing.T) {
+	cfg := &oidcConfig{
+		groupsClaim:   "groups",
+		allowedEmails: map[string]string{"anna@example.com": roleAdmin, "ben@example.com": roleViewer},
+		allowedGroups: map[string]string{"Moderators": roleModerator, "family": roleViewer},
+	}
+	tests := []struct {
+		name        string
+		claims      map[string]any
+		wantSubject string
+		wantRole    string
+	}{
+		{
+			name:        "email",
+			claims:      map[string]any{"sub": "1", "email": "anna@example.com", "email_verified": true},
+			wantSubject: "anna@example.com", wantRole: roleAdmin,
+		},
+		{
+			name:        "email case and spaces",
+			claims:      map[string]any{"sub": "1", "email": " Anna@Example.COM "},
+			wantSubject: "anna@example.com", wantRole: roleAdmin,
+		},
+		{
+			name:        "unverified email",
+			claims:      map[string]any{"sub": "1", "email": "anna@example.com", "email_verified": false},
+			wantSubject: "1", wantRole: "",
+		},
+		{
+			name:        "group list",
+			claims:      map[string]any{"sub": "2", "groups": []any{"family", 7, "Moderators"}},
+			wantSubject: "2", wantRole: roleModerator,
+		},
+		{
+			name:        "group string",
+			claims:      map[string]any{"sub": "2", "groups": "family, other"},
+			wantSubject: "2", wantRole: roleViewer,
+		},
+		{
+			name:        "groups are case sensitive",
+			claims:      map[string]any{"sub": "2", "groups": []any{"moderators"}},
+			wantSubject: "2", wantRole: "",
+		},
+		{
+			name:        "highest role wins",
+			claims:      map[string]any{"sub": "3", "email": "ben@example.com", "groups": []any{"Moderators"}},
+			wantSubject: "ben@example.com", wantRole: roleModerator,
+		},
+		{
+			name:        "no match",
+			claims:      map[string]any{"sub": "4", "email": "eve@example.com", "groups": []any{"guests"}},
+			wantSubject: "eve@example.com", wantRole: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, role := cfg.roleForClaims(tt.claims)
+			if subject != tt.wantSubject || role != tt.wantRole {
+				t.Errorf("roleForClaims = (%q, %q), want (%q, %q)", subject, role, tt.wantSubject, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	s := &server{sessions: sessionConfig{secret: []byte("test secret")}}
+	valid := oidcLoginState{State: "st", Nonce: "no", Verifier: "ve", ExpiresAt: time.Now().Add(oidcStateTTL)}
+	cookieFor := func(ls oidcLoginState) *http.Cookie {
+		c, err := s.oidcStateCookie(httptest.NewRequest(http.MethodGet, "/admin/oidc/login", nil), ls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tamper := func(c *http.Cookie) *http.Cookie {
+		payload, sig, _ := strings.Cut(c.Value, ".")
+		raw, _ := base64.RawURLEncoding.DecodeString(payload)
+		var ls oidcLoginState
+		json.Unmarshal(raw, &ls)
+		ls.State = "attacker"
+		raw, _ = json.Marshal(ls)
+		return &http.Cookie{Name: c.Name, Value: base64.RawURLEncoding.EncodeToString(raw) + "." + sig}
+	}
+	otherKey := &server{sessions: sessionConfig{secret: []byte("other secret")}}
+	foreign, err := otherKey.oidcStateCookie(httptest.NewRequest(http.MethodGet, "/", nil), valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		wantOK bool
+	}{
+		{"valid", cookieFor(valid), true},
+		{"missing", nil, false},
+		{"expired", cookieFor(oidcLoginState{State: "st", ExpiresAt: time.Now().Add(-time.Second)}), false},
+		{"tampered payload", tamper(cookieFor(valid)), false},
+		{"other key", foreign, false},
+		{"unsigned", &http.Cookie{Name: oidcStateCookieName, Value: "e30"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/oidc/callback", nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			ls, ok := s.oidcStateFromRequest(r)
+			if ok != tt.wantOK {
+				t.Fatalf("oidcStateFromRequest ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (ls.State != valid.State || ls.Nonce != valid.Nonce || ls.Verifier != valid.Verifier) {
+				t.Errorf("state = %+v, want %+v", ls, valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder with image.Decode
+)
+
+const (
+	maxPhotoBytes     = 10 << 20 // 10MB
+	maxPhotoSide      = 8192
+	maxPhotoPixels    = 40_000_000
+	minPhotoSide      = 16
+	thumbnailMaxSide  = 320
+	photoJPEGQuality  = 90
+	thumbJPEGQuality  = 80
+	photoListLimit    = 200
+	mimeJPEG          = "image/jpeg"
+	mimePNG           = "image/png"
+	thumbnailMimeType = mimeJPEG
+)
+
+var (
+	errPhotoUnsupported = reject(rejectUnsupported, "unsupported image type; use JPEG, PNG or WebP")
+	errPhotoHEIC        = reject(rejectUnsupported, "HEIC photos must be converted to JPEG before upload")
+	errPhotoTooLarge    = reject(rejectTooLarge, "photo dimensions exceed limit")
+	errPhotoTooSmall    = reject(rejectInvalid, "photo is too small")
+	errPhotoCorrupt     = reject(rejectInvalid, "photo could not be decoded")
+)
+
+type photoMessageMetadata struct {
+	ID         int       `json:"id"`
+	EntryID    int       `json:"entry_id"`
+	GuestName  string    `json:"guest_name"`
+	Caption    string    `json:"caption"`
+	MimeType   string    `json:"mime_type"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	ByteSize   int       `json:"byte_size"`
+	Approved   bool      `json:"approved"`
+	InviteID   string    `json:"invite_id"`
+	TableLabel string    `json:"table_label"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const photoMessageColumns = `id, COALESCE(entry_id, 0), guest_name, caption, mime_type, width, height, byte_size, approved, invite_id, table_label, created_at`
+
+func scanPhotoMessage(row pgx.Row) (photoMessageMetadata, error) {
+	var pm photoMessageMetadata
+	err := row.Scan(&pm.ID, &pm.EntryID, &pm.GuestName, &pm.Caption, &pm.MimeType, &pm.Width, &pm.Height, &pm.ByteSize, &pm.Approved, &pm.InviteID, &pm.TableLabel, &pm.CreatedAt)
+	return pm, err
+}
+
+// processedPhoto is an upload after it has been re-encoded. Re-encoding is
+// what strips EXIF, GPS, XMP and any other metadata the camera wrote.
+type processedPhoto struct {
+	image     []byte
+	mimeType  string
+	width     int
+	height    int
+	thumbnail []byte
+}
+
+// sniffPhoto identifies an upload from its magic bytes; the client's
+// Content-Type is never trusted.
+func sniffPhoto(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg", nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png", nil
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp", nil
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return "", errPhotoHEIC
+		}
+	}
+	return "", errPhotoUnsupported
+}
+
+// processPhoto validates an upload, applies the EXIF orientation, and returns
+// a metadata-free copy plus a thumbnail. Dimensions are checked from the
+// header before the full decode so oversized images never get allocated.
+func processPhoto(data []byte) (*processedPhoto, error) {
+	format, err := sniffPhoto(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decodedFormat != format {
+		return nil, errPhotoCorrupt
+	}
+	if cfg.Width > maxPhotoSide || cfg.Height > maxPhotoSide || cfg.Width*cfg.Height > maxPhotoPixels {
+		return nil, errPhotoTooLarge
+	}
+	if cfg.Width < minPhotoSide || cfg.Height < minPhotoSide {
+		return nil, errPhotoTooSmall
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errPhotoCorrupt
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	// PNG stays PNG so transparency survives; WebP becomes PNG only when it
+	// actually has transparency, since the standard library cannot write WebP.
+	out := &processedPhoto{width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+	var buf bytes.Buffer
+	if format == "png" || (format == "webp" && !isOpaque(img)) {
+		out.mimeType = mimePNG
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	} else {
+		out.mimeType = mimeJPEG
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: photoJPEGQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	out.image = buf.Bytes()
+
+	thumb, err := makeThumbnail(img)
+	if err != nil {
+		return nil, err
+	}
+	out.thumbnail = thumb
+	return out, nil
+}
+
+// makeThumbnail scales img so its longer side is at most thumbnailMaxSide
+// and encodes it as JPEG.
+func makeThumbnail(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbnailMaxSide || h > thumbnailMaxSide {
+		if w >= h {
+			w, h = thumbnailMaxSide, max(1, h*thumbnailMaxSide/w)
+		} else {
+			w, h = max(1, w*thumbnailMaxSide/h), thumbnailMaxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.Draw(dst, dst.Bounds(), image.White, image.Point{}, xdraw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// flatten composites transparent pixels onto white, as JPEG has no alpha.
+func flatten(img image.Image) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	xdraw.Draw(dst, dst.Bounds(), image.White, image.Point{}, xdraw.Src)
+	xdraw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, xdraw.Over)
+	return dst
+}
+
+// jpegOrientation reads the EXIF Orientation tag (0x0112) from a JPEG's APP1
+// segment. It returns 1 (upright) when there is none or it cannot be parsed.
+func jpegOrientation(data []byte) int {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan / end of image
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + size
+		if size < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and mirrors img so it displays upright once the
+// EXIF tag is gone.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	xdraw.Draw(src, src.Bounds(), img, b.Min, xdraw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// rejectPhoto answers a processPhoto error: the guest's fault is a counted
+// rejection, anything else a server error.
+func (s *server) rejectPhoto(w http.ResponseWriter, r *http.Request, kind string, err error) {
+	var rej *rejection
+	if !errors.As(err, &rej) {
+		slog.ErrorContext(r.Context(), "process photo", "err", err)
+		serverError(w, r, "failed to process photo")
+		return
+	}
+	s.rejectSubmission(w, r, kind, err)
+}
+
+const insertPhotoQuery = `
+INSERT INTO photo_messages (entry_id, guest_name, caption, image, mime_type, width, height, byte_size, thumbnail, invite_id, invite_kind, table_label, invite_guest, edit_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+RETURNING id, created_at`
+
+// handlePhotoMessageUpload accepts a multipart form with a "photo" file, the
+// guest's name, an optional caption and an optional invite token.
+func (s *server) handlePhotoMessageUpload(w http.ResponseWriter, r *http.Request) {
+	// Explicit CORS headers for public endpoint
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Invite-Token")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoBytes+64*1024)
+	if err := r.ParseMultipartForm(maxPhotoBytes + 64*1024); err != nil {
+		s.rejectSubmission(w, r, submissionPhoto, payloadRejection(err, "invalid photo payload"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	inviteToken := r.FormValue("invite")
+	if inviteToken == "" {
+		inviteToken = r.Header.Get(inviteHeaderName)
+	}
+	invite, err := s.inviteForSubmission(inviteToken)
+	if err != nil {
+		s.rejectSubmission(w, r, submissionPhoto, reject(rejectInvite, inviteErrorMessage(err)))
+		return
+	}
+	inviteID, inviteKind, tableLabel, inviteGuest := inviteColumns(invite)
+	limits := s.limitsFor(invite)
+
+	guestName := strings.TrimSpace(r.FormValue("name"))
+	if guestName == "" {
+		s.rejectSubmission(w, r, submissionPhoto, reject(rejectEmptyName, "name is required"))
+		return
+	}
+	if len([]rune(guestName)) > limits.MaxNameLength {
+		s.rejectSubmission(w, r, submissionPhoto, reject(rejectTooLong, "name is too long"))
+		return
+	}
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	if len([]rune(caption)) > limits.MaxMessageLength {
+		s.rejectSubmission(w, r, submissionPhoto, reject(rejectTooLong, "caption too long"))
+		return
+	}
+
+	data, _, err := readUpload(r, "photo", maxPhotoBytes)
+	if err != nil {
+		s.rejectSubmission(w, r, submissionPhoto, err)
+		return
+	}
+
+	photo, err := processPhoto(data)
+	if err != nil {
+		s.rejectPhoto(w, r, submissionPhoto, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	editToken, editTokenHash, err := s.newEditToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "generate edit token", "err", err)
+		serverError(w, r, "failed to store photo")
+		return
+	}
+
+	var id int
+	var createdAt time.Time
+	entryID, err := s.insertWithEntry(ctx, guestName, invite, "", func(tx pgx.Tx, entryID int) error {
+		return tx.QueryRow(ctx, insertPhotoQuery, entryID, guestName, caption, photo.image, photo.mimeType, photo.width, photo.height, len(photo.image), photo.thumbnail,
+			inviteID, inviteKind, tableLabel, inviteGuest, editTokenHash).Scan(&id, &createdAt)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "insert photo message", "err", err)
+		serverError(w, r, "failed to store photo")
+		return
+	}
+	s.metrics.submitted.WithLabelValues(submissionPhoto).Inc()
+
+	writeJSON(w, http.StatusCreated, s.receipt(id, entryID, editToken, createdAt))
+}
+
+// handlePhotoMessageEdit lets the holder of a receipt change the caption
+// (PATCH) or delete (DELETE) their photo at /photo-message/{id}.
+func (s *server) handlePhotoMessageEdit(w http.ResponseWriter, r *http.Request) {
+	setGuestEditCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, ok := guestEditID(r.URL.Path, "/photo-message/")
+	if !ok || (r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	var payload struct {
+		Caption string `json:"caption"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid photo payload", http.StatusBadRequest)
+			return
+		}
+		payload.Caption = strings.TrimSpace(payload.Caption)
+		if len([]rune(payload.Caption)) > s.limits.MaxMessageLength {
+			clientError(w, r, "caption too long", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := s.checkEditToken(ctx, "photo_messages", id, r.Header.Get(editTokenHeaderName)); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := s.deleteEntryItem(ctx, "photo_messages", id); err != nil {
+			slog.ErrorContext(r.Context(), "delete photo message", "err", err)
+			serverError(w, r, "failed to delete photo")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if _, err := s.pool.Exec(ctx, `UPDATE photo_messages SET caption = $2, edited_at = NOW() WHERE id = $1`, id, payload.Caption); err != nil {
+		slog.ErrorContext(r.Context(), "update photo message", "err", err)
+		serverError(w, r, "failed to update photo")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) listPhotoMessages(ctx context.Context, limit int) ([]photoMessageMetadata, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+photoMessageColumns+` FROM photo_messages ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []photoMessageMetadata
+	for rows.Next() {
+		pm, err := scanPhotoMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pm)
+	}
+	return out, rows.Err()
+}
+
+func (s *server) handlePhotoMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	payload, err := s.listPhotoMessages(ctx, photoListLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query photo messages", "err", err)
+		serverError(w, r, "failed to fetch photos")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, payload)
+}
+
+// handlePhotoFile serves /photo-messages/{id}/image and
+// /photo-messages/{id}/thumbnail.
+func (s *server) handlePhotoFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	idStr, variant, ok := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/photo-messages/"), "/"), "/")
+	if !ok || (variant != "image" && variant != "thumbnail") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT image, mime_type FROM photo_messages WHERE id = $1`
+	if variant == "thumbnail" {
+		query = `SELECT thumbnail, '` + thumbnailMimeType + `' FROM photo_messages WHERE id = $1`
+	}
+	var data []byte
+	var mimeType string
+	if err := s.pool.QueryRow(ctx, query, id).Scan(&data, &mimeType); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+}
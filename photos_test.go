@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifAPP1 builds an APP1 segment holding a TIFF header and one IFD with
+// the given tags, each a SHORT value.
+func exifAPP1(order binary.AppendByteOrder, tags map[uint16]uint16) []byte {
+	tiff := []byte("II")
+	if order == binary.BigEndian {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, uint16(len(tags)))
+	for _, tag := range []uint16{0x010F, 0x0112, 0x011A} {
+		v, ok := tags[tag]
+		if !ok {
+			continue
+		}
+		tiff = order.AppendUint16(tiff, tag)
+		tiff = order.AppendUint16(tiff, 3) // SHORT
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint16(tiff, v)
+		tiff = append(tiff, 0, 0)
+	}
+	tiff = order.AppendUint32(tiff, 0)
+	body := append([]byte("Exif\x00\x00"), tiff...)
+	return jpegSegment(0xE1, body)
+}
+
+func jpegSegment(marker byte, body []byte) []byte {
+	return append(binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(body)+2)), body...)
+}
+
+// withSegments inserts segments right after the SOI marker of a JPEG.
+func withSegments(jpg []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, jpg[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+// landscapeJPEG is 40×20: red on the left half, blue on the right.
+func landscapeJPEG(t testing.TB) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	sos := []byte{0xFF, 0xDA, 0x00, 0x02}
+	file := func(segments ...[]byte) []byte {
+		return withSegments(append(soi, sos...), segments...)
+	}
+	app0 := jpegSegment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	rotated := exifAPP1(binary.BigEndian, map[uint16]uint16{0x0112: 6})
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", file(rotated), 6},
+		{"little endian", file(exifAPP1(binary.LittleEndian, map[uint16]uint16{0x0112: 8})), 8},
+		{"after other tags", file(exifAPP1(binary.BigEndian, map[uint16]uint16{0x010F: 1, 0x0112: 3, 0x011A: 72})), 3},
+		{"after APP0", file(app0, exifAPP1(binary.LittleEndian, map[uint16]uint16{0x0112: 5})), 5},
+		{"no orientation tag", file(exifAPP1(binary.BigEndian, map[uint16]uint16{0x010F: 1})), 1},
+		{"no EXIF", file(app0), 1},
+		{"orientation 0", file(exifAPP1(binary.BigEndian, map[uint16]uint16{0x0112: 0})), 1},
+		{"orientation 9", file(exifAPP1(binary.BigEndian, map[uint16]uint16{0x0112: 9})), 1},
+		{"APP1 after scan", append(append(soi, sos...), rotated...), 1},
+		{"bad byte order", file(bytes.Replace(rotated, []byte("MM"), []byte("XX"), 1)), 1},
+		{"segment longer than file", append(soi, rotated[:len(rotated)-4]...), 1},
+		{"IFD offset past end", file(jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A\x00\x00\xFF\xFF"))), 1},
+		{"entry count past end", file(jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\x00\x09"))), 1},
+		{"not a marker", append(soi, 0x00, 0x01, 0x02, 0x03), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: jpegOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessPhotoAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		orientation   uint16
+		width, height int
+		// topLeftRed is whether the red half ends up at the top left.
+		topLeftRed bool
+	}{
+		{1, 40, 20, true},
+		{2, 40, 20, false},
+		{3, 40, 20, false},
+		{6, 20, 40, true},
+		{8, 20, 40, false},
+	}
+	base := landscapeJPEG(t)
+	for _, tt := range tests {
+		data := withSegments(base, exifAPP1(binary.BigEndian, map[uint16]uint16{0x0112: tt.orientation}))
+		p, err := processPhoto(data)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		if p.width != tt.width || p.height != tt.height {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, p.width, p.height, tt.width, tt.height)
+		}
+		if bytes.Contains(p.image, []byte("Exif")) {
+			t.Errorf("orientation %d: EXIF survived processing", tt.orientation)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(p.image))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, b, _ := img.At(2, 2).RGBA()
+		if red := r > b; red != tt.topLeftRed {
+			t.Errorf("orientation %d: top-left pixel red = %v, want %v", tt.orientation, red, tt.topLeftRed)
+		}
+	}
+}
+
+func FuzzJPEGOrientation(f *testing.F) {
+	f.Add(withSegments(landscapeJPEG(f), exifAPP1(binary.BigEndian, map[uint16]uint16{0x0112: 6})))
+	f.Add(withSegments(landscapeJPEG(f), exifAPP1(binary.LittleEndian, map[uint16]uint16{0x010F: 1, 0x0112: 8})))
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x02})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if o := jpegOrientation(data); o < 1 || o > 8 {
+			t.Fatalf("jpegOrientation = %d, want 1 to 8", o)
+		}
+	})
+}
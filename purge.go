@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// purgeTargets maps each `purge` target to its DELETE statements, in
+// order. $1 is the cutoff: rows that ended, or for content were created,
+// before it go.
+var purgeTargets = map[string][]struct{ label, query string }{
+	"jobs": {
+		{"finished jobs", `DELETE FROM jobs WHERE status IN ('done', 'dead') AND COALESCE(finished_at, updated_at) < $1`},
+	},
+	"sessions": {
+		{"ended sessions", `DELETE FROM admin_sessions WHERE COALESCE(revoked_at, expires_at) < $1`},
+	},
+	"tokens": {
+		{"revoked or expired tokens", `DELETE FROM api_tokens WHERE COALESCE(revoked_at, expires_at) < $1`},
+	},
+	"content": {
+		{"messages", `DELETE FROM messages WHERE created_at < $1`},
+		{"voice messages", `DELETE FROM voice_messages WHERE created_at < $1`},
+		{"photo messages", `DELETE FROM photo_messages WHERE created_at < $1`},
+		{"video messages", `DELETE FROM video_messages WHERE created_at < $1`},
+		{"empty entries", `DELETE FROM entries e WHERE created_at < $1
+  AND NOT EXISTS (SELECT 1 FROM messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM voice_messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM photo_messages WHERE entry_id = e.id)
+  AND NOT EXISTS (SELECT 1 FROM video_messages WHERE entry_id = e.id)`},
+	},
+}
+
+// runPurge implements `purge [-older-than d] [-dry-run] [-yes]
+// jobs|sessions|tokens|content`. Finished jobs are kept for a week by
+// default so failures can still be inspected; deleting guest content needs
+// an explicit -older-than and -yes.
+func runPurge(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	opts := configFlags(flags)
+	olderThan := flags.Duration("older-than", -1, "only rows that ended (content: were created) this long ago; default 168h for jobs, 0 otherwise")
+	dryRun := flags.Bool("dry-run", false, "report what would be deleted and roll back")
+	yes := flags.Bool("yes", false, "confirm deleting content")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: purge [-older-than duration] [-dry-run] [-yes] jobs|sessions|tokens|content")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one target")
+	}
+	target := flags.Arg(0)
+	steps, ok := purgeTargets[target]
+	if !ok {
+		return fmt.Errorf("unknown target %q", target)
+	}
+	if *olderThan < 0 {
+		switch target {
+		case "jobs":
+			*olderThan = 7 * 24 * time.Hour
+		case "content":
+			return errors.New("purge content needs -older-than, e.g. -older-than 8760h for a year")
+		default:
+			*olderThan = 0
+		}
+	}
+	if target == "content" && !*yes && !*dryRun {
+		return errors.New("purge content deletes guest messages and recordings; pass -yes to confirm or -dry-run to preview")
+	}
+	cutoff := time.Now().Add(-*olderThan)
+
+	pool, err := openDB(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+	for _, step := range steps {
+		tag, err := tx.Exec(ctx, step.query, cutoff)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", step.label, err)
+		}
+		fmt.Fprintf(stdout, "%s: %d deleted\n", step.label, tag.RowsAffected())
+	}
+	if *dryRun {
+		fmt.Fprintln(stdout, "dry run: nothing was deleted")
+		return nil
+	}
+	return tx.Commit(ctx)
+}
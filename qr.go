@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize    = 512
+	minQRSize        = 64
+	maxQRSize        = 2048
+	maxTableCards    = 200
+	defaultCardTitle = "Leave us a message"
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrOptions are the query parameters shared by /admin/qr.png and
+// /admin/qr.svg: ec (L, M, Q or H), size in pixels and border=false to drop
+// the quiet zone.
+type qrOptions struct {
+	level  qrcode.RecoveryLevel
+	size   int
+	border bool
+}
+
+func parseQROptions(r *http.Request, defaultLevel string) (qrOptions, error) {
+	q := r.URL.Query()
+	opts := qrOptions{size: defaultQRSize, border: q.Get("border") != "false"}
+	ec := strings.ToUpper(q.Get("ec"))
+	if ec == "" {
+		ec = defaultLevel
+	}
+	level, ok := qrLevels[ec]
+	if !ok {
+		return opts, fmt.Errorf("ec must be one of L, M, Q, H")
+	}
+	opts.level = level
+	if raw := q.Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < minQRSize || size > maxQRSize {
+			return opts, fmt.Errorf("size must be between %d and %d", minQRSize, maxQRSize)
+		}
+		opts.size = size
+	}
+	return opts, nil
+}
+
+// qrTarget picks what the code points at: an existing signed invite
+// (?invite=), a freshly minted table or guest invite (?table= / ?guest=), or
+// the plain public URL.
+func (s *server) qrTarget(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	base := s.publicBaseURL(r)
+	if token := q.Get("invite"); token != "" {
+		if _, err := s.verifyInvite(token); err != nil {
+			return "", fmt.Errorf("invite: %w", err)
+		}
+		return inviteURL(base, token), nil
+	}
+	kind, label := "", ""
+	switch {
+	case q.Get("table") != "":
+		kind, label = inviteKindTable, q.Get("table")
+	case q.Get("guest") != "":
+		kind, label = inviteKindGuest, q.Get("guest")
+	case q.Get("kind") == inviteKindEvent:
+		kind = inviteKindEvent
+	default:
+		return base + "/", nil
+	}
+	if len(s.invites.secret) == 0 {
+		return "", fmt.Errorf("INVITE_SECRET is not configured")
+	}
+	if len([]rune(label)) > maxInviteLabelLen {
+		return "", fmt.Errorf("label is too long")
+	}
+	expiresAt, err := parseInviteExpiry(q.Get("expires_in"))
+	if err != nil {
+		return "", fmt.Errorf("invalid expires_in")
+	}
+	inv, err := s.newInvite(kind, s.invites.event, strings.TrimSpace(label), expiresAt, base)
+	if err != nil {
+		return "", err
+	}
+	return inv.URL, nil
+}
+
+// handleQRCode serves /admin/qr.png and /admin/qr.svg.
+func (s *server) handleQRCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := parseQROptions(r, "M")
+	if err != nil {
+		clientError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := s.qrTarget(r)
+	if err != nil {
+		clientError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code, err := qrcode.New(target, opts.level)
+	if err != nil {
+		clientError(w, r, "content too long for a QR code", http.StatusBadRequest)
+		return
+	}
+	code.DisableBorder = !opts.border
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-QR-Content", target)
+	if strings.HasSuffix(r.URL.Path, ".svg") {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		if _, err := w.Write([]byte(qrSVG(code, opts.size))); err != nil {
+			slog.ErrorContext(r.Context(), "write qr svg", "err", err)
+		}
+		return
+	}
+	png, err := code.PNG(opts.size)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "encode qr png", "err", err)
+		serverError(w, r, "failed to render qr code")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if _, err := w.Write(png); err != nil {
+		slog.ErrorContext(r.Context(), "write qr png", "err", err)
+	}
+}
+
+// qrSVG draws every dark module as part of a single path, which keeps the
+// markup small and scales crisply when printed.
+func qrSVG(code *qrcode.QRCode, size int) string {
+	bitmap := code.Bitmap()
+	n := len(bitmap)
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, n, n, n, n, path.String())
+}
+
+type tableCard struct {
+	Label string
+	URL   string
+	QR    template.HTML
+}
+
+var tableCardsTemplate = template.Must(template.New("cards").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} – table cards</title>
+<style>
+  @page { size: A4; margin: 12mm; }
+  body { font-family: Georgia, "Times New Roman", serif; margin: 0; color: #1f2937; }
+  .hint { font-family: system-ui, sans-serif; font-size: 13px; color: #6b7280; margin: 12px; }
+  .sheet { display: grid; grid-template-columns: repeat(2, 1fr); gap: 10mm; }
+  .card { border: 1px dashed #9ca3af; border-radius: 6mm; padding: 8mm; text-align: center; break-inside: avoid; }
+  .card h2 { margin: 0 0 2mm; font-size: 20pt; font-weight: normal; }
+  .card .table { font-size: 28pt; margin: 0 0 4mm; }
+  .card svg { width: 60mm; height: 60mm; }
+  .card .caption { font-size: 10pt; color: #6b7280; margin-top: 3mm; }
+  @media print { .hint { display: none; } }
+</style>
+</head>
+<body>
+<p class="hint">Print this page (Ctrl/Cmd + P, or “Save as PDF”). Cut along the dashed lines.</p>
+<div class="sheet">
+{{range .Cards}}  <div class="card">
+    <h2>{{$.Title}}</h2>
+    <p class="table">{{.Label}}</p>
+    {{.QR}}
+    <p class="caption">Scan to write a note or record a voice message</p>
+  </div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// handleTableCards renders a printable sheet of table cards
+// (GET /admin/table-cards?tables=1,2,3). Each card carries its own signed
+// table invite when INVITE_SECRET is set, or the plain public URL otherwise.
+func (s *server) handleTableCards(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	var labels []string
+	for _, label := range strings.Split(q.Get("tables"), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		clientError(w, r, "tables is required, e.g. ?tables=1,2,3", http.StatusBadRequest)
+		return
+	}
+	if len(labels) > maxTableCards {
+		clientError(w, r, "too many tables", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseQROptions(r, "Q")
+	if err != nil {
+		clientError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := parseInviteExpiry(q.Get("expires_in"))
+	if err != nil {
+		clientError(w, r, "invalid expires_in", http.StatusBadRequest)
+		return
+	}
+	title := strings.TrimSpace(q.Get("title"))
+	if title == "" {
+		title = defaultCardTitle
+	}
+
+	base := s.publicBaseURL(r)
+	cards := make([]tableCard, 0, len(labels))
+	for _, label := range labels {
+		if len([]rune(label)) > maxInviteLabelLen {
+			clientError(w, r, "label is too long", http.StatusBadRequest)
+			return
+		}
+		target := base + "/"
+		if len(s.invites.secret) > 0 {
+			inv, err := s.newInvite(inviteKindTable, s.invites.event, label, expiresAt, base)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "mint table invite", "err", err)
+				serverError(w, r, "failed to mint invites")
+				return
+			}
+			target = inv.URL
+		}
+		code, err := qrcode.New(target, opts.level)
+		if err != nil {
+			clientError(w, r, "content too long for a QR code", http.StatusBadRequest)
+			return
+		}
+		code.DisableBorder = !opts.border
+		cards = append(cards, tableCard{
+			Label: label,
+			URL:   target,
+			// qrSVG only emits numbers and fixed markup, so it is safe to inline.
+			QR: template.HTML(qrSVG(code, opts.size)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tableCardsTemplate.Execute(w, map[string]any{"Title": title, "Cards": cards}); err != nil {
+		slog.ErrorContext(r.Context(), "render table cards", "err", err)
+	}
+}
@@ -37,7 +37,7 @@ if [ ! -d "$ROOT/monitor/node_modules" ]; then
 fi
 
 echo "→ Starting Go API on :${PORT:-3000}"
-go run main.go &
+go run . &
 GO_PID=$!
 
 echo "→ Starting guest app (frontend) on :5173"
@@ -46,8 +46,8 @@ FRONT_PID=$!
 
 MONITOR_API_BASE="${VITE_API_BASE:-http://localhost:3000}"
 MONITOR_PORT="${MONITOR_PORT:-4174}"
-echo "→ Starting monitor app on :${MONITOR_PORT} with ${MONITOR_PM} (API_BASE=${MONITOR_API_BASE})"
-(cd "$ROOT/monitor" VITE_API_BASE="$MONITOR_API_BASE" VITE_ADMIN_USERNAME="$ADMIN_USERNAME" VITE_ADMIN_PASSWORD="$ADMIN_PASSWORD" ${MONITOR_PM} run dev -- --host --port "${MONITOR_PORT}") &
+echo "→ Starting monitor app on :${MONITOR_PORT} with ${MONITOR_PM} (proxying API to ${MONITOR_API_BASE})"
+(cd "$ROOT/monitor" && VITE_API_PROXY="$MONITOR_API_BASE" ${MONITOR_PM} run dev -- --host --port "${MONITOR_PORT}") &
 MONITOR_PID=$!
 
 trap 'echo "→ Shutting down"; kill "$GO_PID" "$FRONT_PID" "$MONITOR_PID" 2>/dev/null || true' EXIT
@@ -31,7 +31,7 @@ if [ ! -d "$ROOT/frontend/node_modules" ]; then
 fi
 
 echo "→ Starting Go API on :${PORT:-3000}"
-go run main.go &
+go run . &
 GO_PID=$!
 
 echo "→ Starting Vite dev server on :5173"
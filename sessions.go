@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	sessionCookieName = "guestbook_admin"
+	csrfHeaderName    = "X-CSRF-Token"
+	defaultSessionTTL = 12 * time.Hour
+	sessionIDBytes    = 32
+	maxLoginBodyBytes = 4 << 10
+)
+
+var errNoSession = errors.New("no admin session")
+
+type adminSession struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`
+	CSRFToken  string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// sessionConfig controls how admin session cookies are signed and scoped.
+type sessionConfig struct {
+	secret   []byte
+	ttl      time.Duration
+	secure   string // "auto", "true" or "false"
+	sameSite http.SameSite
+}
+
+func sessionConfigFrom(c *config) sessionConfig {
+	cfg := sessionConfig{
+		secret:   []byte(c.Sessions.Secret),
+		ttl:      c.Sessions.TTL,
+		secure:   strings.ToLower(c.Sessions.CookieSecure),
+		sameSite: http.SameSiteLaxMode,
+	}
+	if len(cfg.secret) == 0 {
+		cfg.secret = make([]byte, 32)
+		if _, err := rand.Read(cfg.secret); err != nil {
+			slog.Error("generate session secret", "err", err)
+			os.Exit(1)
+		}
+		slog.Warn("SESSION_SECRET not set; using a random key, so admin sessions end when the server restarts")
+	}
+	switch strings.ToLower(c.Sessions.CookieSameSite) {
+	case "strict":
+		cfg.sameSite = http.SameSiteStrictMode
+	case "none":
+		cfg.sameSite = http.SameSiteNoneMode
+		cfg.secure = "true"
+	}
+	return cfg
+}
+
+// handleAdminLogin exchanges the admin credentials for a session cookie and
+// returns the CSRF token the client must echo on state-changing requests.
+func (s *server) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.passwordAuthEnabled() {
+		clientError(w, r, "password login is not configured", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodyBytes)
+	var payload struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid login payload", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			clientError(w, r, "invalid login payload", http.StatusBadRequest)
+			return
+		}
+		payload.Username = r.FormValue("username")
+		payload.Password = r.FormValue("password")
+	}
+
+	ip := s.clientIP(r)
+	if wait := s.authLimiter.lockedFor(ip, payload.Username); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		clientError(w, r, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if !s.credentialsMatch(payload.Username, payload.Password) {
+		s.authLimiter.recordFailure(ip, payload.Username)
+		clientError(w, r, "invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	s.authLimiter.recordSuccess(ip, payload.Username)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	sess, rawID, err := s.createSession(ctx, payload.Username, roleAdmin, ip, r.UserAgent())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "create admin session", "err", err)
+		serverError(w, r, "failed to start session")
+		return
+	}
+	s.setSessionCookie(w, r, rawID, sess.ExpiresAt)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, sessionResponse(sess))
+}
+
+// handleAdminLogout revokes the caller's session and clears the cookie.
+func (s *server) handleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	sess, err := s.sessionFromRequest(ctx, r)
+	if err == nil {
+		if !validCSRF(sess, r) {
+			clientError(w, r, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+		if err := s.revokeSession(ctx, sess.ID); err != nil {
+			slog.ErrorContext(r.Context(), "revoke admin session", "err", err)
+			serverError(w, r, "failed to end session")
+			return
+		}
+	}
+	s.clearSessionCookie(w, r)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleAdminSession reports the current session so a reloaded monitor can
+// pick up its CSRF token without logging in again.
+func (s *server) handleAdminSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	sess, err := s.sessionFromRequest(ctx, r)
+	if err != nil {
+		if !errors.Is(err, errNoSession) {
+			slog.ErrorContext(r.Context(), "load admin session", "err", err)
+		}
+		clientError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, sessionResponse(sess))
+}
+
+// handleAdminSessions lists active sessions (GET /admin/sessions) and revokes
+// one by ID (POST /admin/sessions/{id}/revoke).
+func (s *server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	remainder := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/sessions"), "/")
+	if remainder == "" {
+		if r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		sessions, err := s.listSessions(ctx)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "list admin sessions", "err", err)
+			serverError(w, r, "failed to fetch sessions")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, sessions)
+		return
+	}
+
+	id, ok := strings.CutSuffix(remainder, "/revoke")
+	if !ok || r.Method != http.MethodPost || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := s.revokeSession(ctx, id); err != nil {
+		slog.ErrorContext(r.Context(), "revoke admin session", "err", err)
+		serverError(w, r, "failed to revoke session")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func sessionResponse(sess *adminSession) map[string]any {
+	return map[string]any{
+		"username":   sess.Username,
+		"role":       sess.Role,
+		"csrf_token": sess.CSRFToken,
+		"expires_at": sess.ExpiresAt,
+	}
+}
+
+// createSession stores a new session and returns it with the raw ID that goes
+// into the cookie. Only a hash of the raw ID is persisted.
+func (s *server) createSession(ctx context.Context, username, role, ip, userAgent string) (*adminSession, string, error) {
+	rawID, err := randomToken(sessionIDBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	csrf, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	sess := &adminSession{
+		ID:        hashToken(rawID),
+		Username:  username,
+		Role:      role,
+		CSRFToken: csrf,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	const insertSession = `
+INSERT INTO admin_sessions (id, username, role, csrf_token, ip, user_agent, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW() + $7::interval)
+RETURNING created_at, expires_at, last_seen_at`
+	ttl := strconv.FormatInt(int64(s.sessions.ttl/time.Second), 10) + " seconds"
+	if err := s.pool.QueryRow(ctx, insertSession, sess.ID, username, role, csrf, ip, userAgent, ttl).
+		Scan(&sess.CreatedAt, &sess.ExpiresAt, &sess.LastSeenAt); err != nil {
+		return nil, "", err
+	}
+	if _, err := s.pool.Exec(ctx, `DELETE FROM admin_sessions WHERE expires_at < NOW() - INTERVAL '7 days'`); err != nil {
+		slog.ErrorContext(ctx, "prune admin sessions", "err", err)
+	}
+	return sess, rawID, nil
+}
+
+// sessionFromRequest verifies the cookie signature and loads the matching
+// live session, refreshing its last-seen time.
+func (s *server) sessionFromRequest(ctx context.Context, r *http.Request) (*adminSession, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, errNoSession
+	}
+	rawID, ok := s.verifySignedValue(cookie.Value)
+	if !ok {
+		return nil, errNoSession
+	}
+	sess := &adminSession{ID: hashToken(rawID)}
+	const touchSession = `
+UPDATE admin_sessions SET last_seen_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+RETURNING username, role, csrf_token, created_at, expires_at, last_seen_at, ip, user_agent`
+	err = s.pool.QueryRow(ctx, touchSession, sess.ID).
+		Scan(&sess.Username, &sess.Role, &sess.CSRFToken, &sess.CreatedAt, &sess.ExpiresAt, &sess.LastSeenAt, &sess.IP, &sess.UserAgent)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *server) listSessions(ctx context.Context) ([]adminSession, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, username, role, created_at, expires_at, last_seen_at, ip, user_agent FROM admin_sessions WHERE revoked_at IS NULL AND expires_at > NOW() ORDER BY last_seen_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []adminSession{}
+	for rows.Next() {
+		var sess adminSession
+		if err := rows.Scan(&sess.ID, &sess.Username, &sess.Role, &sess.CreatedAt, &sess.ExpiresAt, &sess.LastSeenAt, &sess.IP, &sess.UserAgent); err != nil {
+			return nil, err
+		}
+		out = append(out, sess)
+	}
+	return out, rows.Err()
+}
+
+func (s *server) revokeSession(ctx context.Context, id string) error {
+	_, err := s.pool.Exec(ctx, `UPDATE admin_sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	return err
+}
+
+func (s *server) setSessionCookie(w http.ResponseWriter, r *http.Request, rawID string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    s.signValue(rawID),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.sessions.sameSite,
+	})
+}
+
+func (s *server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.sessions.sameSite,
+	})
+}
+
+func (s *server) cookieSecure(r *http.Request) bool {
+	switch s.sessions.secure {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if r.TLS != nil {
+		return true
+	}
+	return s.trustProxyHeaders && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// signValue and verifySignedValue protect small cookie payloads with the
+// session secret.
+func (s *server) signValue(value string) string {
+	mac := hmac.New(sha256.New, s.sessions.secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *server) verifySignedValue(signed string) (string, bool) {
+	i := strings.LastIndexByte(signed, '.')
+	if i <= 0 {
+		return "", false
+	}
+	value := signed[:i]
+	if !hmac.Equal([]byte(s.signValue(value)), []byte(signed)) {
+		return "", false
+	}
+	return value, true
+}
+
+// validCSRF requires the session's CSRF token on every request that is not a
+// safe method.
+func validCSRF(sess *adminSession, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	got := r.Header.Get(csrfHeaderName)
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRFToken)) == 1
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignedValue(t *testing.T) {
+	s := &server{sessions: sessionConfig{secret: []byte("test secret")}}
+	other := &server{sessions: sessionConfig{secret: []byte("other secret")}}
+	signed := s.signValue("raw-session-id")
+
+	tests := []struct {
+		name   string
+		signed string
+		want   string
+		wantOK bool
+	}{
+		{"valid", signed, "raw-session-id", true},
+		{"dots in value", s.signValue("a.b.c"), "a.b.c", true},
+		{"other key", other.signValue("raw-session-id"), "", false},
+		{"swapped value", "other-session-id" + signed[len("raw-session-id"):], "", false},
+		{"truncated signature", signed[:len(signed)-1], "", false},
+		{"unsigned", "raw-session-id", "", false},
+		{"signature only", signed[len("raw-session-id"):], "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := s.verifySignedValue(tt.signed)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("verifySignedValue(%q) = %q, %v; want %q, %v", tt.signed, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// Cookies that fail the signature check are turned away before the
+// database is asked, so a nil pool is enough here.
+func TestSessionFromRequestRejectsBadCookies(t *testing.T) {
+	s := &server{sessions: sessionConfig{secret: []byte("test secret")}}
+	other := &server{sessions: sessionConfig{secret: []byte("other secret")}}
+	for name, value := range map[string]string{
+		"empty":     "",
+		"unsigned":  "raw-session-id",
+		"other key": other.signValue("raw-session-id"),
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/session", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: value})
+		if _, err := s.sessionFromRequest(context.Background(), r); !errors.Is(err, errNoSession) {
+			t.Errorf("%s: err = %v, want errNoSession", name, err)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/admin/session", nil)
+	if _, err := s.sessionFromRequest(context.Background(), r); !errors.Is(err, errNoSession) {
+		t.Errorf("no cookie: err = %v, want errNoSession", err)
+	}
+}
+
+func TestValidCSRF(t *testing.T) {
+	sess := &adminSession{CSRFToken: "csrf-token"}
+	tests := []struct {
+		method string
+		token  string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodHead, "", true},
+		{http.MethodOptions, "", true},
+		{http.MethodPost, "csrf-token", true},
+		{http.MethodPost, "", false},
+		{http.MethodPost, "wrong", false},
+		{http.MethodPost, "csrf-token-and-more", false},
+		{http.MethodPatch, "", false},
+		{http.MethodPut, "", false},
+		{http.MethodDelete, "", false},
+		{http.MethodDelete, "csrf-token", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/admin/messages/1", nil)
+		if tt.token != "" {
+			r.Header.Set(csrfHeaderName, tt.token)
+		}
+		if got := validCSRF(sess, r); got != tt.want {
+			t.Errorf("validCSRF(%s, %q) = %v, want %v", tt.method, tt.token, got, tt.want)
+		}
+	}
+}
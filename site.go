@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// siteEntry is one approved message or voice note on the static site and
+// in its search.json.
+type siteEntry struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	GuestName  string    `json:"guest_name"`
+	TableLabel string    `json:"table_label"`
+	Text       string    `json:"text"`
+	Transcript string    `json:"transcript,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Audio      string    `json:"audio,omitempty"`
+	// AudioWAV is the loudness-normalized copy, for browsers such as
+	// Safari that cannot play the WebM original.
+	AudioWAV string `json:"audio_wav,omitempty"`
+	MimeType string `json:"-"`
+	Duration int    `json:"duration_seconds,omitempty"`
+}
+
+// runSite implements `site [-out dir] [-title text] [-tz zone]`: it writes
+// every approved message and voice note as a static website that needs no
+// server, with index.html, the clips under audio/ and search.json.
+func runSite(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("site", flag.ContinueOnError)
+	opts := configFlags(flags)
+	out := flags.String("out", "site", "directory to write; must be empty or missing")
+	title := flags.String("title", defaultBookTitle, "page title")
+	tz := flags.String("tz", "", "time zone for dates, e.g. Europe/Lisbon (default: local)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	loc := time.Local
+	if *tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			return err
+		}
+	}
+	pool, err := openDB(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := prepareSiteDir(*out); err != nil {
+		return err
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	entries, err := collectRows(ctx, tx, `SELECT `+messageColumns+` FROM messages WHERE approved ORDER BY created_at, id`, func(row pgx.Row) (siteEntry, error) {
+		m, err := scanMessage(row)
+		return siteEntry{ID: fmt.Sprintf("m%d", m.ID), Type: "message", GuestName: m.GuestName, TableLabel: m.TableLabel, Text: m.Text, CreatedAt: m.CreatedAt}, err
+	})
+	if err != nil {
+		return err
+	}
+	voices, err := copySiteAudio(ctx, tx, *out)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, voices...)
+	slices.SortStableFunc(entries, func(a, b siteEntry) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	for i := range entries {
+		entries[i].CreatedAt = entries[i].CreatedAt.In(loc)
+	}
+
+	index, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*out, "search.json"), index, 0o644); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(*out, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := siteTemplate.Execute(f, map[string]any{"Title": *title, "Entries": entries, "Voices": len(voices), "Messages": len(entries) - len(voices)}); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %d messages and %d voice notes to %s\n", len(entries)-len(voices), len(voices), *out)
+	return nil
+}
+
+// prepareSiteDir creates dir, refusing to write over an existing site.
+func prepareSiteDir(dir string) error {
+	existing, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return os.MkdirAll(filepath.Join(dir, "audio"), 0o755)
+}
+
+// copySiteAudio writes approved voice clips to dir/audio one row at a
+// time, with the normalized WAV next to the original when there is one.
+func copySiteAudio(ctx context.Context, tx pgx.Tx, dir string) ([]siteEntry, error) {
+	const query = `SELECT ` + voiceMessageColumns + `, audio, normalized_audio FROM voice_messages WHERE approved ORDER BY created_at, id`
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []siteEntry
+	for rows.Next() {
+		var vm voiceMessageMetadata
+		var audio, normalized []byte
+		err := rows.Scan(&vm.ID, &vm.EntryID, &vm.GuestName, &vm.Note, &vm.DurationSeconds, &vm.MimeType, &vm.Approved, &vm.InviteID, &vm.TableLabel, &vm.CreatedAt,
+			&vm.Waveform, &vm.Transcript, &vm.TranscriptLanguage, &vm.TranscriptStatus, &vm.AudioStatus, &vm.LoudnessLUFS, &audio, &normalized)
+		if err != nil {
+			return nil, err
+		}
+		name := "audio/" + strings.TrimPrefix(voiceArchiveName(vm), "voice/")
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), audio, 0o644); err != nil {
+			return nil, err
+		}
+		e := siteEntry{
+			ID: fmt.Sprintf("v%d", vm.ID), Type: "voice", GuestName: vm.GuestName, TableLabel: vm.TableLabel, Text: vm.Note,
+			Transcript: vm.Transcript, CreatedAt: vm.CreatedAt, Audio: name, MimeType: vm.MimeType, Duration: vm.DurationSeconds,
+		}
+		if len(normalized) > 0 {
+			e.AudioWAV = strings.TrimSuffix(name, path.Ext(name)) + ".normalized.wav"
+			if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(e.AudioWAV)), normalized, 0o644); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+var siteTemplate = template.Must(template.New("site").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: Georgia, serif; max-width: 42rem; margin: 2rem auto; padding: 0 1rem; color: #2b2118; background: #fdfaf3; }
+  h1 { font-weight: normal; text-align: center; margin-bottom: 0.25rem; }
+  .summary { text-align: center; color: #8c7c6c; margin-top: 0; }
+  input[type=search] { width: 100%; box-sizing: border-box; padding: 0.6rem 0.8rem; font: inherit; border: 1px solid #d8cfc2; border-radius: 8px; margin: 1rem 0; }
+  article { border-bottom: 1px solid #e8e0d4; padding: 1rem 0; }
+  .who { font-weight: bold; }
+  .when { color: #8c7c6c; font-size: 0.85rem; }
+  p { white-space: pre-wrap; }
+  .transcript { font-style: italic; }
+  audio { width: 100%; margin-top: 0.5rem; }
+  .empty { text-align: center; color: #8c7c6c; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="summary">{{.Messages}} messages · {{.Voices}} voice notes</p>
+<input type="search" id="search" placeholder="Search names and messages" aria-label="Search">
+<main id="entries">
+{{range .Entries}}<article id="{{.ID}}">
+  <div class="who">{{if .GuestName}}{{.GuestName}}{{else}}Anonymous{{end}}{{if .TableLabel}} · {{.TableLabel}}{{end}}</div>
+  <div class="when">{{.CreatedAt.Format "2 January 2006, 15:04"}}{{if .Audio}} · voice note, {{.Duration}} s{{end}}</div>
+  {{if .Text}}<p>{{.Text}}</p>{{end}}
+  {{if .Audio}}<audio controls preload="none">
+    <source src="{{.Audio}}" type="{{.MimeType}}">
+    {{if .AudioWAV}}<source src="{{.AudioWAV}}" type="audio/wav">{{end}}
+  </audio>{{end}}
+  {{if .Transcript}}<p class="transcript">“{{.Transcript}}”</p>{{end}}
+</article>
+{{else}}<p class="empty">No approved messages yet.</p>
+{{end}}
+</main>
+<p class="empty" id="no-results" hidden>Nothing matches your search.</p>
+<script>
+(() => {
+  const input = document.getElementById('search')
+  const articles = Array.from(document.querySelectorAll('#entries article'))
+  const empty = document.getElementById('no-results')
+  // search.json holds the text of every entry. Pages opened from disk
+  // cannot fetch it, so fall back to the text on the page.
+  let haystack = new Map(articles.map((a) => [a.id, a.textContent.toLowerCase()]))
+  fetch('search.json')
+    .then((r) => (r.ok ? r.json() : Promise.reject()))
+    .then((entries) => {
+      haystack = new Map(entries.map((e) => [e.id, [e.guest_name, e.table_label, e.text, e.transcript || ''].join(' ').toLowerCase()]))
+    })
+    .catch(() => {})
+  input.addEventListener('input', () => {
+    const terms = input.value.toLowerCase().split(/\s+/).filter(Boolean)
+    let shown = 0
+    for (const a of articles) {
+      const text = haystack.get(a.id) || ''
+      const match = terms.every((t) => text.includes(t))
+      a.hidden = !match
+      if (match) shown++
+    }
+    empty.hidden = shown > 0
+  })
+})()
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scopes an API token can be granted. Admins signed in through a session or
+// Basic auth implicitly hold all of them.
+const (
+	scopeReadMessages = "read:messages"
+	scopeReadAudio    = "read:audio"
+	scopeReadPhotos   = "read:photos"
+	scopeReadVideo    = "read:video"
+	scopeModerate     = "moderate"
+	scopeExport       = "export"
+	scopeMetrics      = "read:metrics"
+)
+
+var allScopes = []string{scopeReadMessages, scopeReadAudio, scopeReadPhotos, scopeReadVideo, scopeModerate, scopeExport, scopeMetrics}
+
+const (
+	apiTokenPrefix     = "gbt_"
+	maxTokenNameLength = 80
+)
+
+type authMethod string
+
+const (
+	authMethodOpen    authMethod = "open"
+	authMethodBasic   authMethod = "basic"
+	authMethodSession authMethod = "session"
+	authMethodToken   authMethod = "token"
+)
+
+// adminPrincipal describes who is calling an admin route and what they may do.
+type adminPrincipal struct {
+	method  authMethod
+	subject string
+	role    string
+	scopes  []string
+	tokenID int
+}
+
+func (p *adminPrincipal) hasScope(scope string) bool {
+	return p != nil && slices.Contains(p.scopes, scope)
+}
+
+type adminPrincipalKey struct{}
+
+func withAdminPrincipal(ctx context.Context, p *adminPrincipal) context.Context {
+	return context.WithValue(ctx, adminPrincipalKey{}, p)
+}
+
+func adminPrincipalFromContext(ctx context.Context) *adminPrincipal {
+	p, _ := ctx.Value(adminPrincipalKey{}).(*adminPrincipal)
+	return p
+}
+
+// requireScopeFromContext is the GraphQL counterpart of requireScope.
+func requireScopeFromContext(ctx context.Context, scope string) error {
+	if !adminPrincipalFromContext(ctx).hasScope(scope) {
+		return fmt.Errorf("forbidden: %s scope required", scope)
+	}
+	return nil
+}
+
+type apiToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// handleAPITokens lists tokens (GET /admin/tokens), mints one
+// (POST /admin/tokens) and revokes one (POST /admin/tokens/{id}/revoke).
+func (s *server) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	remainder := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tokens"), "/")
+	switch {
+	case remainder == "" && r.Method == http.MethodGet:
+		tokens, err := s.listAPITokens(ctx)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "list api tokens", "err", err)
+			serverError(w, r, "failed to fetch tokens")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, tokens)
+	case remainder == "" && r.Method == http.MethodPost:
+		s.createAPIToken(ctx, w, r)
+	case strings.HasSuffix(remainder, "/revoke") && r.Method == http.MethodPost:
+		id, err := strconv.Atoi(strings.TrimSuffix(remainder, "/revoke"))
+		if err != nil || id <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		revoked, err := s.revokeAPIToken(ctx, id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "revoke api token", "err", err)
+			serverError(w, r, "failed to revoke token")
+			return
+		}
+		if !revoked {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) createAPIToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var payload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresIn string     `json:"expires_in"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		clientError(w, r, "invalid token payload", http.StatusBadRequest)
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		clientError(w, r, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(payload.Name)) > maxTokenNameLength {
+		clientError(w, r, "name is too long", http.StatusBadRequest)
+		return
+	}
+	scopes, err := validTokenScopes(payload.Scopes)
+	if err != nil {
+		clientError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiresAt := payload.ExpiresAt
+	if payload.ExpiresIn != "" {
+		d, err := time.ParseDuration(payload.ExpiresIn)
+		if err != nil || d <= 0 {
+			clientError(w, r, "invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		clientError(w, r, "expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	tok, raw, err := s.insertAPIToken(ctx, payload.Name, scopes, adminPrincipalFromContext(r.Context()).subject, expiresAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "insert api token", "err", err)
+		serverError(w, r, "failed to create token")
+		return
+	}
+
+	// The raw token is only ever shown in this response.
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, struct {
+		apiToken
+		Token string `json:"token"`
+	}{tok, raw})
+}
+
+// validTokenScopes checks requested scopes and drops duplicates.
+func validTokenScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	var scopes []string
+	for _, scope := range requested {
+		if !slices.Contains(allScopes, scope) {
+			return nil, errors.New("unknown scope " + strconv.Quote(scope))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// insertAPIToken stores a new token and returns it with its raw value,
+// which is never stored.
+func (s *server) insertAPIToken(ctx context.Context, name string, scopes []string, createdBy string, expiresAt *time.Time) (apiToken, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return apiToken{}, "", err
+	}
+	raw := apiTokenPrefix + secret
+	tok := apiToken{
+		Name:      name,
+		Prefix:    raw[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	const insertToken = `
+INSERT INTO api_tokens (name, token_hash, prefix, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at`
+	err = s.pool.QueryRow(ctx, insertToken, tok.Name, hashToken(raw), tok.Prefix, tok.Scopes, tok.CreatedBy, tok.ExpiresAt).Scan(&tok.ID, &tok.CreatedAt)
+	return tok, raw, err
+}
+
+// revokeAPIToken reports false when there is no active token with id.
+func (s *server) revokeAPIToken(ctx context.Context, id int) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `UPDATE api_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	return tag.RowsAffected() > 0, err
+}
+
+func (s *server) listAPITokens(ctx context.Context) ([]apiToken, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, name, prefix, scopes, created_by, created_at, expires_at, last_used_at, revoked_at FROM api_tokens ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []apiToken{}
+	for rows.Next() {
+		var t apiToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &t.Scopes, &t.CreatedBy, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+var errInvalidAPIToken = errors.New("invalid api token")
+
+// tokenStore is the part of the pool bearer token lookups need, so tests
+// can stand in for it.
+type tokenStore interface {
+	dbExecer
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// activeAt reports whether the token may be used at now: not revoked and
+// not past its expiry.
+func (t apiToken) activeAt(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || t.ExpiresAt.After(now))
+}
+
+// principalForAPIToken resolves a bearer token to its principal and records
+// when it was last used.
+func (s *server) principalForAPIToken(ctx context.Context, raw string) (*adminPrincipal, error) {
+	return apiTokenPrincipal(ctx, s.pool, raw, time.Now())
+}
+
+func apiTokenPrincipal(ctx context.Context, db tokenStore, raw string, now time.Time) (*adminPrincipal, error) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, errInvalidAPIToken
+	}
+	var tok apiToken
+	err := db.QueryRow(ctx, `SELECT id, name, scopes, expires_at, revoked_at FROM api_tokens WHERE token_hash = $1`, hashToken(raw)).
+		Scan(&tok.ID, &tok.Name, &tok.Scopes, &tok.ExpiresAt, &tok.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tok.activeAt(now) {
+		return nil, errInvalidAPIToken
+	}
+	if _, err := db.Exec(ctx, `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`, tok.ID); err != nil {
+		return nil, err
+	}
+	return &adminPrincipal{method: authMethodToken, subject: "token:" + tok.Name, scopes: tok.Scopes, tokenID: tok.ID}, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestValidTokenScopes(t *testing.T) {
+	tests := []struct {
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{requested: []string{scopeExport}, want: []string{scopeExport}},
+		{requested: []string{scopeReadMessages, scopeModerate, scopeReadMessages}, want: []string{scopeReadMessages, scopeModerate}},
+		{requested: allScopes, want: allScopes},
+		{requested: nil, wantErr: true},
+		{requested: []string{}, wantErr: true},
+		{requested: []string{scopeExport, "admin"}, wantErr: true},
+		{requested: []string{"Export"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validTokenScopes(tt.requested)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("validTokenScopes(%q) = %q, want an error", tt.requested, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("validTokenScopes(%q) = %q, %v; want %q", tt.requested, got, err, tt.want)
+		}
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	p := &adminPrincipal{method: authMethodToken, scopes: []string{scopeReadMessages}}
+	ctx := withAdminPrincipal(context.Background(), p)
+	if err := requireScopeFromContext(ctx, scopeReadMessages); err != nil {
+		t.Errorf("granted scope refused: %v", err)
+	}
+	if err := requireScopeFromContext(ctx, scopeModerate); err == nil {
+		t.Error("moderate allowed for a read-only token")
+	}
+	if err := requireScopeFromContext(context.Background(), scopeReadMessages); err == nil {
+		t.Error("scope allowed without a principal")
+	}
+}
+
+// tokenRow is a stored api_tokens row as fakeTokenStore returns it.
+type tokenRow struct {
+	hash     string
+	token    apiToken
+	lastUsed bool
+}
+
+type fakeTokenStore struct{ rows []*tokenRow }
+
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error { return f(dest...) }
+
+func (f *fakeTokenStore) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return scanFunc(func(dest ...any) error {
+		for _, row := range f.rows {
+			if row.hash == args[0] {
+				*dest[0].(*int) = row.token.ID
+				*dest[1].(*string) = row.token.Name
+				*dest[2].(*[]string) = row.token.Scopes
+				*dest[3].(**time.Time) = row.token.ExpiresAt
+				*dest[4].(**time.Time) = row.token.RevokedAt
+				return nil
+			}
+		}
+		return pgx.ErrNoRows
+	})
+}
+
+func (f *fakeTokenStore) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	for _, row := range f.rows {
+		if row.token.ID == args[0] {
+			row.lastUsed = true
+		}
+	}
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func TestAPITokenPrincipal(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	tests := []struct {
+		name    string
+		token   apiToken
+		raw     string
+		stored  string // raw value of the stored token when it is not raw
+		wantErr bool
+	}{
+		{name: "active", token: apiToken{ID: 1, Name: "photos", Scopes: []string{scopeReadPhotos}}, raw: "gbt_active"},
+		{name: "not yet expired", token: apiToken{ID: 2, Name: "export", ExpiresAt: &future}, raw: "gbt_future"},
+		{name: "expired", token: apiToken{ID: 3, ExpiresAt: &past}, raw: "gbt_expired", wantErr: true},
+		{name: "revoked", token: apiToken{ID: 4, RevokedAt: &past}, raw: "gbt_revoked", wantErr: true},
+		{name: "unknown", token: apiToken{ID: 5}, raw: "gbt_unknown", stored: "gbt_other", wantErr: true},
+		{name: "missing prefix", token: apiToken{ID: 6}, raw: "active", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := tt.raw
+			if tt.stored != "" {
+				stored = tt.stored
+			}
+			row := &tokenRow{hash: hashToken(stored), token: tt.token}
+			db := &fakeTokenStore{rows: []*tokenRow{row}}
+			p, err := apiTokenPrincipal(context.Background(), db, tt.raw, now)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidAPIToken) {
+					t.Fatalf("err = %v, want errInvalidAPIToken", err)
+				}
+				if row.lastUsed {
+					t.Error("a rejected token was marked as used")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.tokenID != tt.token.ID || p.subject != "token:"+tt.token.Name || !slices.Equal(p.scopes, tt.token.Scopes) {
+				t.Errorf("principal = %+v, want token %d %q with %q", p, tt.token.ID, tt.token.Name, tt.token.Scopes)
+			}
+			if !row.lastUsed {
+				t.Error("last use was not recorded")
+			}
+		})
+	}
+}
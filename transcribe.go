@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// whisperSampleRate is the only input rate whisper.cpp accepts.
+const whisperSampleRate = 16000
+
+// transcript is what a Transcriber heard in a voice message.
+type transcript struct {
+	Text     string
+	Language string // ISO 639-1 code, empty when unknown
+}
+
+// Transcriber turns a recorded voice message into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, mimeType string) (transcript, error)
+}
+
+// newTranscriber picks the implementation named by TRANSCRIBER: empty
+// turns transcription off and "whisper" runs whisper.cpp. Whisper decodes
+// clips with decoder, so it reads the same formats as loudness
+// normalization.
+func newTranscriber(c *config, decoder *audioNormalizer) (Transcriber, error) {
+	switch name := c.Transcription.Transcriber; name {
+	case "":
+		return nil, nil
+	case "whisper":
+		w := &whisperTranscriber{
+			binary:   c.Transcription.WhisperBin,
+			model:    c.Transcription.WhisperModel,
+			language: c.Transcription.WhisperLanguage,
+			decoder:  decoder,
+		}
+		if _, err := os.Stat(w.model); err != nil {
+			return nil, fmt.Errorf("%s: %w", setting("WHISPER_MODEL"), err)
+		}
+		var err error
+		if w.binary, err = exec.LookPath(w.binary); err != nil {
+			return nil, fmt.Errorf("%s: %w", setting("WHISPER_BIN"), err)
+		}
+		return w, nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q", setting("TRANSCRIBER"), name)
+	}
+}
+
+// whisperTranscriber shells out to a locally installed whisper.cpp CLI.
+// The clip is decoded in Go, or by FFMPEG_BIN for formats such as AAC, and
+// handed over as 16 kHz mono WAV, so whisper.cpp does not need to be built
+// with ffmpeg.
+type whisperTranscriber struct {
+	binary   string
+	model    string
+	language string
+	decoder  *audioNormalizer
+}
+
+func (w *whisperTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType string) (transcript, error) {
+	dir, err := os.MkdirTemp("", "transcribe-")
+	if err != nil {
+		return transcript{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	wav, err := w.wav(ctx, audio)
+	if err != nil {
+		return transcript{}, fmt.Errorf("decode %s: %w", mimeType, err)
+	}
+	input := filepath.Join(dir, "clip.wav")
+	if err := os.WriteFile(input, wav, 0o600); err != nil {
+		return transcript{}, err
+	}
+
+	output := filepath.Join(dir, "clip")
+	cmd := exec.CommandContext(ctx, w.binary, "-m", w.model, "-f", input, "-l", w.language, "-oj", "-of", output, "-np")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return transcript{}, fmt.Errorf("whisper: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	raw, err := os.ReadFile(output + ".json")
+	if err != nil {
+		return transcript{}, err
+	}
+	var result struct {
+		Result struct {
+			Language string `json:"language"`
+		} `json:"result"`
+		Transcription []struct {
+			Text string `json:"text"`
+		} `json:"transcription"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return transcript{}, fmt.Errorf("whisper output: %w", err)
+	}
+	parts := make([]string, 0, len(result.Transcription))
+	for _, seg := range result.Transcription {
+		if text := strings.TrimSpace(seg.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return transcript{Text: strings.Join(parts, " "), Language: result.Result.Language}, nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// wav decodes a clip to 16-bit mono PCM at 16 kHz in a WAV file.
+func (w *whisperTranscriber) wav(ctx context.Context, audio []byte) ([]byte, error) {
+	mono, rate, err := w.decoder.decode(ctx, audio, whisperSampleRate)
+	if err != nil {
+		return nil, err
+	}
+	if rate != whisperSampleRate {
+		mono = resampleLinear(mono, rate, whisperSampleRate)
+	}
+	return encodeWAV(mono, whisperSampleRate), nil
+}
+
+// transcribeVoiceMessage is the transcribe_voice job step that stores a
+// transcript.
+func transcribeVoiceMessage(db dbExecer, t Transcriber) voiceTask {
+	return func(ctx context.Context, id int, audio []byte, mimeType string) error {
+		result, err := t.Transcribe(ctx, audio, mimeType)
+		if err != nil {
+			return err
+		}
+		const save = `UPDATE voice_messages SET transcript = $2, transcript_language = $3, transcribed_at = NOW() WHERE id = $1`
+		_, err = db.Exec(context.WithoutCancel(ctx), save, id, result.Text, result.Language)
+		return err
+	}
+}
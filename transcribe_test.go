@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// execRecorder is a dbExecer that remembers the statements it was given.
+type execRecorder struct {
+	sql  []string
+	args [][]any
+}
+
+func (e *execRecorder) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	e.sql = append(e.sql, sql)
+	e.args = append(e.args, args)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func TestTranscribeVoiceMessageStoresTranscript(t *testing.T) {
+	db := &execRecorder{}
+	task := transcribeVoiceMessage(db, &fakeTranscriber{Text: "Congratulations to you both!", Language: "en"})
+	if err := task(context.Background(), 42, []byte("clip"), "audio/webm"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.args) != 1 {
+		t.Fatalf("ran %d statements, want 1", len(db.args))
+	}
+	args := db.args[0]
+	if len(args) != 3 || args[0] != 42 || args[1] != "Congratulations to you both!" || args[2] != "en" {
+		t.Errorf("stored %v, want [42 Congratulations to you both! en]", args)
+	}
+}
+
+func TestTranscribeVoiceMessageFailure(t *testing.T) {
+	db := &execRecorder{}
+	errWhisper := errors.New("whisper crashed")
+	task := transcribeVoiceMessage(db, &fakeTranscriber{Err: errWhisper})
+	if err := task(context.Background(), 42, []byte("clip"), "audio/webm"); !errors.Is(err, errWhisper) {
+		t.Fatalf("err = %v, want %v", err, errWhisper)
+	}
+	if len(db.sql) != 0 {
+		t.Errorf("stored a transcript after a failure: %v", db.args)
+	}
+}
+
+func TestWhisperWAV(t *testing.T) {
+	w := &whisperTranscriber{decoder: &audioNormalizer{}}
+	wav, err := w.wav(context.Background(), encodeWAV(make([]float32, 48000), 48000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mono, rate, err := decodeMono(wav, whisperSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != whisperSampleRate || len(mono) != whisperSampleRate {
+		t.Errorf("got %d samples at %d Hz, want one second at %d Hz", len(mono), rate, whisperSampleRate)
+	}
+
+	// Without FFMPEG_BIN, AAC in MP4 cannot be read.
+	if _, err := w.wav(context.Background(), mp4Fixture()); !errors.Is(err, errAudioUnsupported) {
+		t.Errorf("err = %v, want %v", err, errAudioUnsupported)
+	}
+}
+
+// fakeTranscriber returns the same result for every clip.
+type fakeTranscriber struct {
+	Text     string
+	Language string
+	Err      error
+}
+
+func (f *fakeTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType string) (transcript, error) {
+	if f.Err != nil {
+		return transcript{}, f.Err
+	}
+	return transcript{Text: f.Text, Language: f.Language}, ctx.Err()
+}
+
+func TestNewTranscriberRejectsFake(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Transcription.Transcriber = "fake"
+	if tr, err := newTranscriber(cfg, &audioNormalizer{}); err == nil {
+		t.Errorf("newTranscriber(fake) = %T, want an error", tr)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultMaxVideoDuration = 30 * time.Second
+	defaultMaxVideoMB       = 25
+	videoListLimit          = 200
+)
+
+var (
+	errVideoUnsupported = reject(rejectUnsupported, "unsupported video type; use MP4 or WebM")
+	errVideoCorrupt     = reject(rejectInvalid, "video could not be read; please record it again")
+)
+
+// Codecs accepted per container, keyed by the container's own codec names.
+var (
+	mp4VideoCodecs  = map[string]string{"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "av01": "av1", "vp09": "vp9"}
+	mp4AudioCodecs  = map[string]string{"mp4a": "aac", "Opus": "opus"}
+	webmVideoCodecs = map[string]string{"V_VP8": "vp8", "V_VP9": "vp9", "V_AV1": "av1"}
+	webmAudioCodecs = map[string]string{"A_OPUS": "opus", "A_VORBIS": "vorbis"}
+)
+
+type videoMessageMetadata struct {
+	ID              int       `json:"id"`
+	EntryID         int       `json:"entry_id"`
+	GuestName       string    `json:"guest_name"`
+	Note            string    `json:"note"`
+	DurationSeconds int       `json:"duration_seconds"`
+	MimeType        string    `json:"mime_type"`
+	VideoCodec      string    `json:"video_codec"`
+	AudioCodec      string    `json:"audio_codec"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+	ByteSize        int       `json:"byte_size"`
+	Approved        bool      `json:"approved"`
+	InviteID        string    `json:"invite_id"`
+	TableLabel      string    `json:"table_label"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+const videoMessageColumns = `id, COALESCE(entry_id, 0), guest_name, note, duration_seconds, mime_type, video_codec, audio_codec, width, height, byte_size, approved, invite_id, table_label, created_at`
+
+func scanVideoMessage(row pgx.Row) (videoMessageMetadata, error) {
+	var vm videoMessageMetadata
+	err := row.Scan(&vm.ID, &vm.EntryID, &vm.GuestName, &vm.Note, &vm.DurationSeconds, &vm.MimeType, &vm.VideoCodec, &vm.AudioCodec, &vm.Width, &vm.Height, &vm.ByteSize,
+		&vm.Approved, &vm.InviteID, &vm.TableLabel, &vm.CreatedAt)
+	return vm, err
+}
+
+// videoProbe is what the container says about an upload. The client's
+// duration and Content-Type are never trusted.
+type videoProbe struct {
+	mimeType   string
+	duration   time.Duration
+	videoCodec string
+	audioCodec string
+	width      int
+	height     int
+}
+
+func probeVideo(data []byte) (*videoProbe, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		f, err := parseWebM(data)
+		if err != nil {
+			return nil, err
+		}
+		v := f.track(1)
+		if v == nil {
+			return nil, errors.New("WebM file has no video track")
+		}
+		p := &videoProbe{mimeType: "video/webm", duration: f.duration, width: v.width, height: v.height}
+		var ok bool
+		if p.videoCodec, ok = webmVideoCodecs[v.codec]; !ok {
+			return nil, reject(rejectUnsupported, fmt.Sprintf("unsupported video codec %q", v.codec))
+		}
+		if a := f.track(2); a != nil {
+			if p.audioCodec, ok = webmAudioCodecs[a.codec]; !ok {
+				return nil, reject(rejectUnsupported, fmt.Sprintf("unsupported audio codec %q", a.codec))
+			}
+		}
+		return p, nil
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		if string(data[8:12]) == "qt  " {
+			return nil, errVideoUnsupported
+		}
+		f, err := parseMP4(data)
+		if err != nil {
+			return nil, err
+		}
+		v := f.track("vide")
+		if v == nil {
+			return nil, errors.New("MP4 file has no video track")
+		}
+		p := &videoProbe{mimeType: "video/mp4", duration: f.totalDuration(), width: v.width, height: v.height}
+		var ok bool
+		if p.videoCodec, ok = mp4VideoCodecs[v.codec]; !ok {
+			return nil, reject(rejectUnsupported, fmt.Sprintf("unsupported video codec %q", v.codec))
+		}
+		if a := f.track("soun"); a != nil {
+			if p.audioCodec, ok = mp4AudioCodecs[a.codec]; !ok {
+				return nil, reject(rejectUnsupported, fmt.Sprintf("unsupported audio codec %q", a.codec))
+			}
+		}
+		return p, nil
+	}
+	return nil, errVideoUnsupported
+}
+
+// handleVideoMessageUpload is the video counterpart of
+// handleVoiceMessageUpload: a multipart form with a "video" file, the guest's
+// name, an optional note and an optional invite token.
+func (s *server) handleVideoMessageUpload(w http.ResponseWriter, r *http.Request) {
+	// Explicit CORS headers for public endpoint
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Invite-Token")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Type, X-Request-ID")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	ceiling := s.uploadCeiling().maxVideoBytes() + 64*1024
+	r.Body = http.MaxBytesReader(w, r.Body, ceiling)
+	if err := r.ParseMultipartForm(ceiling); err != nil {
+		s.rejectSubmission(w, r, submissionVideo, payloadRejection(err, "invalid video payload"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	inviteToken := r.FormValue("invite")
+	if inviteToken == "" {
+		inviteToken = r.Header.Get(inviteHeaderName)
+	}
+	invite, err := s.inviteForSubmission(inviteToken)
+	if err != nil {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectInvite, inviteErrorMessage(err)))
+		return
+	}
+	inviteID, inviteKind, tableLabel, inviteGuest := inviteColumns(invite)
+	limits := s.limitsFor(invite)
+
+	guestName := strings.TrimSpace(r.FormValue("name"))
+	if guestName == "" {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectEmptyName, "name is required"))
+		return
+	}
+	if len([]rune(guestName)) > limits.MaxNameLength {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectTooLong, "name is too long"))
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len([]rune(note)) > limits.MaxMessageLength {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectTooLong, "note too long"))
+		return
+	}
+
+	data, _, err := readUpload(r, "video", limits.maxVideoBytes())
+	if err != nil {
+		s.rejectSubmission(w, r, submissionVideo, err)
+		return
+	}
+	probe, err := probeVideo(data)
+	if err != nil {
+		// Parser errors describe the container, not what the guest can do.
+		var rej *rejection
+		if !errors.As(err, &rej) {
+			slog.WarnContext(r.Context(), "probe video upload", "bytes", len(data), "err", err)
+			err = errVideoCorrupt
+		}
+		s.rejectSubmission(w, r, submissionVideo, err)
+		return
+	}
+	if probe.duration <= 0 {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectInvalid, "could not determine video duration"))
+		return
+	}
+	// Allow half a second of slack for recorders that stop a little late.
+	if probe.duration > limits.MaxVideoDuration+500*time.Millisecond {
+		s.rejectSubmission(w, r, submissionVideo, reject(rejectTooLong, "duration exceeds limit"))
+		return
+	}
+	durationSeconds := max(1, int(math.Round(probe.duration.Seconds())))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	editToken, editTokenHash, err := s.newEditToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "generate edit token", "err", err)
+		serverError(w, r, "failed to store video message")
+		return
+	}
+
+	const insertVideo = `
+INSERT INTO video_messages (entry_id, guest_name, note, video, mime_type, duration_seconds, video_codec, audio_codec, width, height, byte_size, invite_id, invite_kind, table_label, invite_guest, edit_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+RETURNING id, created_at`
+	var id int
+	var createdAt time.Time
+	entryID, err := s.insertWithEntry(ctx, guestName, invite, "", func(tx pgx.Tx, entryID int) error {
+		return tx.QueryRow(ctx, insertVideo, entryID, guestName, note, data, probe.mimeType, durationSeconds, probe.videoCodec, probe.audioCodec, probe.width, probe.height, len(data),
+			inviteID, inviteKind, tableLabel, inviteGuest, editTokenHash).Scan(&id, &createdAt)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "insert video message", "err", err)
+		serverError(w, r, "failed to store video message")
+		return
+	}
+	s.metrics.submitted.WithLabelValues(submissionVideo).Inc()
+
+	writeJSON(w, http.StatusCreated, s.receipt(id, entryID, editToken, createdAt))
+}
+
+// handleVideoMessageEdit lets the holder of a receipt change the note
+// (PATCH) or delete (DELETE) their video at /video-message/{id}.
+func (s *server) handleVideoMessageEdit(w http.ResponseWriter, r *http.Request) {
+	setGuestEditCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, ok := guestEditID(r.URL.Path, "/video-message/")
+	if !ok || (r.Method != http.MethodPatch && r.Method != http.MethodDelete) {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer r.Body.Close()
+
+	var payload struct {
+		Note string `json:"note"`
+	}
+	if r.Method == http.MethodPatch {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			clientError(w, r, "invalid video message payload", http.StatusBadRequest)
+			return
+		}
+		payload.Note = strings.TrimSpace(payload.Note)
+		if len([]rune(payload.Note)) > s.limits.MaxMessageLength {
+			clientError(w, r, "note too long", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	if err := s.checkEditToken(ctx, "video_messages", id, r.Header.Get(editTokenHeaderName)); err != nil {
+		writeEditError(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := s.deleteEntryItem(ctx, "video_messages", id); err != nil {
+			slog.ErrorContext(r.Context(), "delete video message", "err", err)
+			serverError(w, r, "failed to delete video message")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if _, err := s.pool.Exec(ctx, `UPDATE video_messages SET note = $2, edited_at = NOW() WHERE id = $1`, id, payload.Note); err != nil {
+		slog.ErrorContext(r.Context(), "update video message", "err", err)
+		serverError(w, r, "failed to update video message")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) listVideoMessages(ctx context.Context, limit int) ([]videoMessageMetadata, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+videoMessageColumns+` FROM video_messages ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []videoMessageMetadata
+	for rows.Next() {
+		vm, err := scanVideoMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vm)
+	}
+	return out, rows.Err()
+}
+
+func (s *server) handleVideoMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	payload, err := s.listVideoMessages(ctx, videoListLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "query video messages", "err", err)
+		serverError(w, r, "failed to fetch video messages")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, payload)
+}
+
+// handleVideoStream serves /video-messages/{id}/video with Range support so
+// players can seek without downloading the whole clip.
+func (s *server) handleVideoStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	remainder := strings.TrimPrefix(r.URL.Path, "/video-messages/")
+	if !strings.HasSuffix(remainder, "/video") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(strings.Trim(strings.TrimSuffix(remainder, "/video"), "/"))
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveBlob(w, r, "video_messages", "video", "mime_type", id)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVideoUploadHidesParserErrors(t *testing.T) {
+	srv, err := newServer(defaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := mp4Fixture()
+	audioOnly := append(webmHeader(), ebml(ebmlIDSegment,
+		ebml(ebmlIDTracks, ebml(ebmlIDTrackEntry,
+			ebml(ebmlIDTrackNumber, []byte{2}),
+			ebml(ebmlIDTrackType, []byte{2}),
+			ebml(ebmlIDCodecID, []byte("A_OPUS")),
+		)),
+	)...)
+	tests := map[string]struct {
+		video []byte
+		want  string
+	}{
+		"truncated MP4":   {full[:len(full)-10], errVideoCorrupt.Error()},
+		"WebM with audio": {audioOnly, errVideoCorrupt.Error()},
+		"QuickTime":       {append(u32s(20), "ftypqt  "...), errVideoUnsupported.Error()},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			mw.WriteField("name", "Anna")
+			fw, _ := mw.CreateFormFile("video", "clip")
+			fw.Write(tt.video)
+			mw.Close()
+			r := httptest.NewRequest(http.MethodPost, "/video-message", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			srv.handleVideoMessageUpload(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Values of a voice message's transcript_status and audio_status columns,
+// which mirror the job that fills them in for the UI. A transcript is
+// disabled while no transcriber is configured; no job is queued for it.
+const (
+	voiceTaskPending  = "pending"
+	voiceTaskRunning  = "running"
+	voiceTaskDone     = "done"
+	voiceTaskFailed   = "failed"
+	voiceTaskDisabled = "disabled"
+)
+
+// Job kinds for voice messages.
+const (
+	jobTranscribeVoice = "transcribe_voice"
+	jobNormalizeVoice  = "normalize_voice"
+)
+
+type voiceJob struct {
+	VoiceMessageID int `json:"voice_message_id"`
+}
+
+// voiceTask processes one voice message and stores its own results.
+type voiceTask func(ctx context.Context, id int, audio []byte, mimeType string) error
+
+// enqueueVoiceJobs queues the background work for a new voice message. It
+// runs in the transaction that inserts the clip, so a stored clip always
+// has its jobs. Without a transcriber the transcript is marked disabled
+// instead of queueing a job nothing would run.
+func enqueueVoiceJobs(ctx context.Context, db dbExecer, id int, transcribe bool) error {
+	if err := enqueueJob(ctx, db, jobNormalizeVoice, voiceJob{VoiceMessageID: id}); err != nil {
+		return err
+	}
+	if !transcribe {
+		_, err := db.Exec(ctx, `UPDATE voice_messages SET transcript_status = $2 WHERE id = $1`, id, voiceTaskDisabled)
+		return err
+	}
+	return enqueueJob(ctx, db, jobTranscribeVoice, voiceJob{VoiceMessageID: id})
+}
+
+// syncTranscriptJobs brings stored clips in line with the transcriber
+// setting at startup. With a transcriber, disabled transcripts are queued;
+// without one, queued transcription jobs are dropped and their clips
+// marked disabled so they do not wait for a worker that never comes.
+func (s *server) syncTranscriptJobs(ctx context.Context) {
+	const enable = `
+WITH enabled AS (
+  UPDATE voice_messages SET transcript_status = $1, transcript_error = '' WHERE transcript_status = $2 RETURNING id
+)
+INSERT INTO jobs (kind, payload) SELECT $3, jsonb_build_object('voice_message_id', id) FROM enabled`
+	const disable = `
+WITH dropped AS (
+  DELETE FROM jobs WHERE kind = $3 AND status = $4 RETURNING (payload->>'voice_message_id')::int AS id
+)
+UPDATE voice_messages SET transcript_status = $2 WHERE id IN (SELECT id FROM dropped) AND transcript_status = $1`
+	query, args := disable, []any{voiceTaskPending, voiceTaskDisabled, jobTranscribeVoice, jobQueued}
+	if s.transcribing {
+		query, args = enable, []any{voiceTaskPending, voiceTaskDisabled, jobTranscribeVoice}
+	}
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "sync transcription jobs", "transcribing", s.transcribing, "err", err)
+		return
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		slog.InfoContext(ctx, "synced transcription jobs", "transcribing", s.transcribing, "voice_messages", n)
+		s.jobs.notify(jobTranscribeVoice)
+	}
+}
+
+// registerVoiceJob runs task for jobs of kind, keeping statusCol and
+// errorCol (trusted column names) of the voice message in step: running
+// while an attempt is in progress, pending between retries, then done or
+// failed. A clip that cannot be decoded fails without retries. A voice
+// message deleted before its turn finishes the job.
+func (s *server) registerVoiceJob(q *jobQueue, kind, statusCol, errorCol string, opts jobOptions, task voiceTask) {
+	run := func(ctx context.Context, job voiceJob) error {
+		claim := `UPDATE voice_messages SET ` + statusCol + ` = $2 WHERE id = $1 RETURNING audio, mime_type`
+		var audio []byte
+		var mimeType string
+		err := s.pool.QueryRow(ctx, claim, job.VoiceMessageID, voiceTaskRunning).Scan(&audio, &mimeType)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := task(ctx, job.VoiceMessageID, audio, mimeType); err != nil {
+			return voiceTaskError(err)
+		}
+		done := `UPDATE voice_messages SET ` + statusCol + ` = $2, ` + errorCol + ` = '' WHERE id = $1`
+		_, err = s.pool.Exec(context.WithoutCancel(ctx), done, job.VoiceMessageID, voiceTaskDone)
+		return err
+	}
+	onFail := func(ctx context.Context, job voiceJob, jobErr error, dead bool) {
+		status := voiceTaskPending
+		if dead {
+			status = voiceTaskFailed
+		}
+		save := `UPDATE voice_messages SET ` + statusCol + ` = $2, ` + errorCol + ` = $3 WHERE id = $1`
+		if _, err := s.pool.Exec(ctx, save, job.VoiceMessageID, status, jobErr.Error()); err != nil {
+			slog.ErrorContext(ctx, "save voice job status", "kind", kind, "voice_message", job.VoiceMessageID, "err", err)
+		}
+	}
+	registerJob(q, kind, opts, run, onFail)
+}
+
+// voiceTaskError marks clips that cannot be decoded as permanent failures:
+// the stored audio will not change, so retrying would fail the same way.
+func voiceTaskError(err error) error {
+	if errors.Is(err, errAudioUnsupported) || errors.Is(err, errAudioEmpty) {
+		return jobPermanent(err)
+	}
+	return err
+}
+
+// voiceStored wakes the voice workers after a voice message was inserted.
+func (s *server) voiceStored() {
+	s.jobs.notify(jobTranscribeVoice, jobNormalizeVoice)
+}
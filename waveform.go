@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// waveformPeaks is how many bars a voice message waveform has, whatever the
+// length of the clip.
+const waveformPeaks = 100
+
+// envelope keeps the loudest sample of every 10 ms window while audio is
+// decoded, so the whole clip never has to sit in memory as PCM.
+type envelope struct {
+	window  int
+	filled  int
+	current float32
+	maxima  []float32
+}
+
+func newEnvelope(sampleRate int) *envelope {
+	return &envelope{window: max(sampleRate/100, 1)}
+}
+
+// pushInterleaved adds float samples interleaved by channel.
+func (e *envelope) pushInterleaved(samples []float32, channels int) {
+	for i := 0; i+channels <= len(samples); i += channels {
+		for _, v := range samples[i : i+channels] {
+			if v == v { // skip NaN from float WAV files
+				e.current = max(e.current, float32(math.Abs(float64(v))))
+			}
+		}
+		e.filled++
+		if e.filled == e.window {
+			e.maxima = append(e.maxima, e.current)
+			e.filled, e.current = 0, 0
+		}
+	}
+}
+
+// peaks folds the windows into at most n values between 0 and 1, rounded
+// to three decimals to keep the JSON small.
+func (e *envelope) peaks(n int) []float32 {
+	maxima := e.maxima
+	if e.filled > 0 {
+		maxima = append(maxima, e.current)
+	}
+	n = min(n, len(maxima))
+	out := make([]float32, n)
+	for i := range out {
+		start, end := i*len(maxima)/n, (i+1)*len(maxima)/n
+		var peak float32
+		for _, v := range maxima[start:end] {
+			peak = max(peak, v)
+		}
+		out[i] = float32(math.Round(float64(min(peak, 1))*1000) / 1000)
+	}
+	return out
+}
+
+// computeWaveform decodes a voice message and returns its peaks.
+func computeWaveform(audio []byte) ([]float32, error) {
+	var env *envelope
+	err := decodeAudio(audio, 48000, func(samples []float32, channels, sampleRate int) {
+		if env == nil {
+			env = newEnvelope(sampleRate)
+		}
+		env.pushInterleaved(samples, channels)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		return nil, errAudioEmpty
+	}
+	peaks := env.peaks(waveformPeaks)
+	if len(peaks) == 0 {
+		return nil, errAudioEmpty
+	}
+	return peaks, nil
+}
+
+// voiceWaveform is computeWaveform for storing: a clip that cannot be
+// decoded (Safari records AAC in MP4) gets an empty waveform so it is not
+// retried.
+func voiceWaveform(audio []byte) []float32 {
+	peaks, err := computeWaveform(audio)
+	if err != nil {
+		slog.Error("voice waveform", "err", err)
+		return []float32{}
+	}
+	return peaks
+}
+
+// backfillWaveforms computes peaks for voice messages stored before
+// waveforms existed, one clip at a time.
+func (s *server) backfillWaveforms(ctx context.Context) {
+	done := 0
+	for {
+		qctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		var id int
+		var audio []byte
+		err := s.pool.QueryRow(qctx, `SELECT id, audio FROM voice_messages WHERE waveform IS NULL ORDER BY id LIMIT 1`).Scan(&id, &audio)
+		if err == nil {
+			_, err = s.pool.Exec(qctx, `UPDATE voice_messages SET waveform = $2 WHERE id = $1`, id, voiceWaveform(audio))
+		}
+		cancel()
+		if errors.Is(err, pgx.ErrNoRows) {
+			if done > 0 {
+				slog.InfoContext(ctx, "backfilled waveforms", "count", done)
+			}
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "backfill waveforms", "err", err)
+			return
+		}
+		done++
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// Matroska/WebM element IDs, with their length marker bits kept.
+const (
+	ebmlIDHeader        = 0x1A45DFA3
+	ebmlIDDocType       = 0x4282
+	ebmlIDSegment       = 0x18538067
+	ebmlIDSeekHead      = 0x114D9B74
+	ebmlIDInfo          = 0x1549A966
+	ebmlIDTimecodeScale = 0x2AD7B1
+	ebmlIDDuration      = 0x4489
+	ebmlIDTracks        = 0x1654AE6B
+	ebmlIDTrackEntry    = 0xAE
+	ebmlIDTrackNumber   = 0xD7
+	ebmlIDTrackType     = 0x83
+	ebmlIDCodecID       = 0x86
+	ebmlIDCodecPrivate  = 0x63A2
+	ebmlIDVideo         = 0xE0
+	ebmlIDPixelWidth    = 0xB0
+	ebmlIDPixelHeight   = 0xBA
+	ebmlIDCluster       = 0x1F43B675
+	ebmlIDTimecode      = 0xE7
+	ebmlIDSimpleBlock   = 0xA3
+	ebmlIDBlockGroup    = 0xA0
+	ebmlIDBlock         = 0xA1
+	ebmlIDCues          = 0x1C53BB6B
+	ebmlIDChapters      = 0x1043A770
+	ebmlIDTags          = 0x1254C367
+	ebmlIDAttachments   = 0x1941A469
+)
+
+var (
+	errWebMInvalid   = errors.New("not a valid WebM file")
+	errWebMTruncated = errors.New("WebM file is truncated")
+)
+
+type webmTrack struct {
+	number       uint64
+	kind         int // 1 video, 2 audio
+	codec        string
+	codecPrivate []byte
+	width        int
+	height       int
+}
+
+// webmFile is what parseWebM learns about a recording. duration comes from
+// the Info element when present; MediaRecorder output has none, so it falls
+// back to the last block timestamp.
+type webmFile struct {
+	duration       time.Duration
+	timecodeScale  uint64
+	tracks         []webmTrack
+	lastBlockNanos int64
+
+	// onFrame, when set, receives the payload of every unlaced block.
+	onFrame func(t *webmTrack, data []byte)
+}
+
+func (f *webmFile) track(kind int) *webmTrack {
+	for i := range f.tracks {
+		if f.tracks[i].kind == kind {
+			return &f.tracks[i]
+		}
+	}
+	return nil
+}
+
+// readEBMLVint decodes a variable-length integer. IDs keep their marker bit;
+// sizes drop it. unknown reports the reserved all-ones size used by live
+// recorders for elements whose length was not known when written.
+func readEBMLVint(b []byte, keepMarker bool) (val uint64, n int, unknown bool, err error) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false, errWebMInvalid
+	}
+	n = 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(b) < n {
+		return 0, 0, false, errWebMTruncated
+	}
+	val = uint64(b[0])
+	if !keepMarker {
+		val &= uint64(0xFF >> n)
+	}
+	allOnes := val == uint64(0xFF>>n)
+	for _, c := range b[1:n] {
+		val = val<<8 | uint64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	return val, n, allOnes && !keepMarker, nil
+}
+
+// readEBMLHeader returns an element's ID, body size (-1 when unknown) and
+// the length of the header itself.
+func readEBMLHeader(b []byte) (id uint64, size int64, headerLen int, err error) {
+	id, idLen, _, err := readEBMLVint(b, true)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	sz, szLen, unknown, err := readEBMLVint(b[idLen:], false)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if unknown {
+		return id, -1, idLen + szLen, nil
+	}
+	if sz > math.MaxInt32 {
+		return 0, 0, 0, errWebMInvalid
+	}
+	return id, int64(sz), idLen + szLen, nil
+}
+
+// eachEBMLChild calls fn for every child of a sized element body.
+func eachEBMLChild(body []byte, fn func(id uint64, data []byte) error) error {
+	for pos := 0; pos < len(body); {
+		id, size, hl, err := readEBMLHeader(body[pos:])
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			return errWebMInvalid
+		}
+		end := pos + hl + int(size)
+		if end > len(body) {
+			return errWebMTruncated
+		}
+		if err := fn(id, body[pos+hl:end]); err != nil {
+			return err
+		}
+		pos = end
+	}
+	return nil
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// isSegmentChild reports whether id can only appear directly inside a
+// Segment, which is how the end of an unknown-size Cluster is found.
+func isSegmentChild(id uint64) bool {
+	switch id {
+	case ebmlIDCluster, ebmlIDCues, ebmlIDInfo, ebmlIDTracks, ebmlIDTags, ebmlIDChapters, ebmlIDAttachments, ebmlIDSeekHead:
+		return true
+	}
+	return false
+}
+
+// parseWebM reads the header, track list and block timestamps of a WebM
+// file held in memory.
+func parseWebM(data []byte) (*webmFile, error) {
+	return parseWebMFrames(data, nil)
+}
+
+// parseWebMFrames is parseWebM that also hands each frame to onFrame, in
+// file order. Tracks are known by then, since Tracks precedes the clusters.
+func parseWebMFrames(data []byte, onFrame func(t *webmTrack, data []byte)) (*webmFile, error) {
+	id, size, hl, err := readEBMLHeader(data)
+	if err != nil || id != ebmlIDHeader || size < 0 || hl+int(size) > len(data) {
+		return nil, errWebMInvalid
+	}
+	docType := ""
+	if err := eachEBMLChild(data[hl:hl+int(size)], func(id uint64, b []byte) error {
+		if id == ebmlIDDocType {
+			docType = string(b)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if docType != "webm" {
+		return nil, errWebMInvalid
+	}
+
+	rest := data[hl+int(size):]
+	id, size, hl, err = readEBMLHeader(rest)
+	if err != nil || id != ebmlIDSegment {
+		return nil, errWebMInvalid
+	}
+	segment := rest[hl:]
+	if size >= 0 {
+		if int(size) > len(segment) {
+			return nil, errWebMTruncated
+		}
+		segment = segment[:size]
+	}
+
+	f := &webmFile{timecodeScale: 1_000_000, lastBlockNanos: -1, onFrame: onFrame}
+	var rawDuration float64
+	for pos := 0; pos < len(segment); {
+		id, size, hl, err := readEBMLHeader(segment[pos:])
+		if err != nil {
+			return nil, err
+		}
+		body := segment[pos+hl:]
+		if size >= 0 {
+			if int(size) > len(body) {
+				return nil, errWebMTruncated
+			}
+			body = body[:size]
+		} else if id != ebmlIDCluster {
+			return nil, errWebMInvalid
+		}
+		switch id {
+		case ebmlIDInfo:
+			err = eachEBMLChild(body, func(id uint64, b []byte) error {
+				switch id {
+				case ebmlIDTimecodeScale:
+					if v := ebmlUint(b); v > 0 {
+						f.timecodeScale = v
+					}
+				case ebmlIDDuration:
+					rawDuration = ebmlFloat(b)
+				}
+				return nil
+			})
+		case ebmlIDTracks:
+			err = eachEBMLChild(body, func(id uint64, b []byte) error {
+				if id != ebmlIDTrackEntry {
+					return nil
+				}
+				t, err := parseWebMTrack(b)
+				if err == nil {
+					f.tracks = append(f.tracks, t)
+				}
+				return err
+			})
+		case ebmlIDCluster:
+			var n int
+			n, err = f.parseCluster(body, size < 0)
+			if size < 0 {
+				size = int64(n)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos += hl + int(size)
+	}
+
+	if rawDuration > 0 {
+		f.duration = time.Duration(rawDuration * float64(f.timecodeScale))
+	} else if f.lastBlockNanos >= 0 {
+		f.duration = time.Duration(f.lastBlockNanos)
+	}
+	return f, nil
+}
+
+func parseWebMTrack(body []byte) (webmTrack, error) {
+	var t webmTrack
+	err := eachEBMLChild(body, func(id uint64, b []byte) error {
+		switch id {
+		case ebmlIDTrackNumber:
+			t.number = ebmlUint(b)
+		case ebmlIDTrackType:
+			t.kind = int(ebmlUint(b))
+		case ebmlIDCodecID:
+			t.codec = string(b)
+		case ebmlIDCodecPrivate:
+			t.codecPrivate = b
+		case ebmlIDVideo:
+			return eachEBMLChild(b, func(id uint64, v []byte) error {
+				switch id {
+				case ebmlIDPixelWidth:
+					t.width = int(ebmlUint(v))
+				case ebmlIDPixelHeight:
+					t.height = int(ebmlUint(v))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return t, err
+}
+
+// parseCluster records block timestamps. For an unknown-size cluster it
+// stops at the next Segment-level element and returns how far it read.
+func (f *webmFile) parseCluster(body []byte, unknownSize bool) (int, error) {
+	var clusterTime int64
+	pos := 0
+	for pos < len(body) {
+		id, size, hl, err := readEBMLHeader(body[pos:])
+		if err != nil {
+			return 0, err
+		}
+		if unknownSize && isSegmentChild(id) {
+			break
+		}
+		if size < 0 || pos+hl+int(size) > len(body) {
+			return 0, errWebMTruncated
+		}
+		data := body[pos+hl : pos+hl+int(size)]
+		switch id {
+		case ebmlIDTimecode:
+			clusterTime = int64(ebmlUint(data))
+		case ebmlIDSimpleBlock:
+			f.noteBlock(clusterTime, data)
+		case ebmlIDBlockGroup:
+			_ = eachEBMLChild(data, func(id uint64, b []byte) error {
+				if id == ebmlIDBlock {
+					f.noteBlock(clusterTime, b)
+				}
+				return nil
+			})
+		}
+		pos += hl + int(size)
+	}
+	return pos, nil
+}
+
+func (f *webmFile) noteBlock(clusterTime int64, block []byte) {
+	track, n, _, err := readEBMLVint(block, false)
+	if err != nil || len(block) < n+2 {
+		return
+	}
+	rel := int64(int16(binary.BigEndian.Uint16(block[n:])))
+	if nanos := (clusterTime + rel) * int64(f.timecodeScale); nanos > f.lastBlockNanos {
+		f.lastBlockNanos = nanos
+	}
+	// Flags follow the timecode; bits 1-2 select lacing, which recorders
+	// do not use for audio, so laced blocks are not split up.
+	if f.onFrame == nil || len(block) <= n+3 || block[n+2]&0x06 != 0 {
+		return
+	}
+	for i := range f.tracks {
+		if f.tracks[i].number == track {
+			f.onFrame(&f.tracks[i], block[n+3:])
+		}
+	}
+}
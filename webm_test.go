@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// ebmlUnknownSize marks an element whose length the writer did not know.
+const ebmlUnknownSize = -1
+
+// ebmlElement builds an element from its ID, with the marker bits as in
+// the ebmlID constants, and its body parts. size ebmlUnknownSize writes
+// the reserved all-ones size instead of the real one.
+func ebmlElement(id uint64, size int, parts ...[]byte) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	var b []byte
+	for shift := 56; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	switch {
+	case size == ebmlUnknownSize:
+		b = append(b, 0xFF)
+	case len(body) < 0x7F:
+		b = append(b, 0x80|byte(len(body)))
+	default:
+		b = append(b, 0x01)
+		b = append(b, binary.BigEndian.AppendUint64(nil, uint64(len(body)))[1:]...)
+	}
+	return append(b, body...)
+}
+
+func ebml(id uint64, parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	return ebmlElement(id, n, parts...)
+}
+
+func ebmlUintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func simpleBlock(track byte, rel int16, frame string) []byte {
+	b := []byte{0x80 | track}
+	b = binary.BigEndian.AppendUint16(b, uint16(rel))
+	return ebml(ebmlIDSimpleBlock, append(append(b, 0x80), frame...))
+}
+
+func webmHeader() []byte {
+	return ebml(ebmlIDHeader, ebml(ebmlIDDocType, []byte("webm")))
+}
+
+func webmTracks() []byte {
+	return ebml(ebmlIDTracks,
+		ebml(ebmlIDTrackEntry,
+			ebml(ebmlIDTrackNumber, []byte{1}),
+			ebml(ebmlIDTrackType, []byte{1}),
+			ebml(ebmlIDCodecID, []byte("V_VP8")),
+			ebml(ebmlIDVideo, ebml(ebmlIDPixelWidth, ebmlUintBytes(320)), ebml(ebmlIDPixelHeight, []byte{0, 240})),
+		),
+		ebml(ebmlIDTrackEntry,
+			ebml(ebmlIDTrackNumber, []byte{2}),
+			ebml(ebmlIDTrackType, []byte{2}),
+			ebml(ebmlIDCodecID, []byte("A_OPUS")),
+		),
+	)
+}
+
+// liveWebMFixture is shaped like MediaRecorder output: unknown-size
+// segment and clusters and no duration, so it comes from the last block.
+func liveWebMFixture() []byte {
+	return append(webmHeader(), ebmlElement(ebmlIDSegment, ebmlUnknownSize,
+		ebml(ebmlIDInfo, ebml(ebmlIDTimecodeScale, ebmlUintBytes(1_000_000))),
+		webmTracks(),
+		ebmlElement(ebmlIDCluster, ebmlUnknownSize,
+			ebml(ebmlIDTimecode, []byte{0}),
+			simpleBlock(1, 0, "v0"),
+			simpleBlock(2, 20, "a0"),
+			simpleBlock(1, 1000, "v1"),
+		),
+		ebmlElement(ebmlIDCluster, ebmlUnknownSize,
+			ebml(ebmlIDTimecode, []byte{0x07, 0xD0}),
+			simpleBlock(1, 500, "v2"),
+			ebml(ebmlIDBlockGroup, ebml(ebmlIDBlock, simpleBlock(2, 480, "a1")[2:])),
+		),
+	)...)
+}
+
+// seekableWebMFixture has sizes everywhere and a float duration in Info.
+func seekableWebMFixture() []byte {
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(3000))
+	return append(webmHeader(), ebml(ebmlIDSegment,
+		ebml(ebmlIDInfo, ebml(ebmlIDDuration, duration)),
+		webmTracks(),
+		ebml(ebmlIDCluster, ebml(ebmlIDTimecode, []byte{0}), simpleBlock(1, 0, "v0")),
+	)...)
+}
+
+func TestParseWebM(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		duration time.Duration
+		frames   int
+	}{
+		{"unknown-size clusters", liveWebMFixture(), 2500 * time.Millisecond, 5},
+		{"sized with duration", seekableWebMFixture(), 3 * time.Second, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := 0
+			f, err := parseWebMFrames(tt.data, func(*webmTrack, []byte) { frames++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.duration != tt.duration {
+				t.Errorf("duration = %v, want %v", f.duration, tt.duration)
+			}
+			if frames != tt.frames {
+				t.Errorf("got %d frames, want %d", frames, tt.frames)
+			}
+			v, a := f.track(1), f.track(2)
+			if v == nil || a == nil {
+				t.Fatalf("tracks = %+v, want video and audio", f.tracks)
+			}
+			if v.codec != "V_VP8" || v.width != 320 || v.height != 240 {
+				t.Errorf("video = %s %dx%d, want V_VP8 320x240", v.codec, v.width, v.height)
+			}
+			if a.codec != "A_OPUS" {
+				t.Errorf("audio codec = %q, want A_OPUS", a.codec)
+			}
+		})
+	}
+}
+
+func TestParseWebMInvalid(t *testing.T) {
+	full := seekableWebMFixture()
+	tests := map[string][]byte{
+		"empty":     nil,
+		"zero byte": {0},
+		"not webm":  ebml(ebmlIDHeader, ebml(ebmlIDDocType, []byte("matroska"))),
+		"truncated": full[:len(full)-3],
+		"unknown-size info": append(webmHeader(), ebmlElement(ebmlIDSegment, ebmlUnknownSize,
+			ebmlElement(ebmlIDInfo, ebmlUnknownSize))...),
+		"oversized child": append(webmHeader(), ebml(ebmlIDSegment,
+			ebml(ebmlIDTracks, []byte{0xAE, 0x90}))...),
+	}
+	for name, data := range tests {
+		if _, err := parseWebM(data); err == nil {
+			t.Errorf("%s: parseWebM accepted it", name)
+		}
+	}
+}
+
+func TestReadEBMLVint(t *testing.T) {
+	tests := []struct {
+		in         []byte
+		keepMarker bool
+		val        uint64
+		n          int
+		unknown    bool
+		wantErr    bool
+	}{
+		{in: []byte{0x81}, val: 1, n: 1},
+		{in: []byte{0x40, 0x02}, val: 2, n: 2},
+		{in: []byte{0x1A, 0x45, 0xDF, 0xA3}, keepMarker: true, val: ebmlIDHeader, n: 4},
+		{in: []byte{0xFF}, val: 0x7F, n: 1, unknown: true},
+		{in: []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, val: 1<<56 - 1, n: 8, unknown: true},
+		{in: []byte{0x40}, wantErr: true},
+		{in: []byte{0x00, 0x01}, wantErr: true},
+		{in: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		val, n, unknown, err := readEBMLVint(tt.in, tt.keepMarker)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("readEBMLVint(% x) accepted it", tt.in)
+			}
+			continue
+		}
+		if err != nil || val != tt.val || n != tt.n || unknown != tt.unknown {
+			t.Errorf("readEBMLVint(% x) = %#x, %d, %v, %v; want %#x, %d, %v", tt.in, val, n, unknown, err, tt.val, tt.n, tt.unknown)
+		}
+	}
+}
+
+func FuzzParseWebM(f *testing.F) {
+	f.Add(liveWebMFixture())
+	f.Add(seekableWebMFixture())
+	f.Add(webmHeader())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseWebMFrames(data, func(*webmTrack, []byte) {})
+		probeVideo(data)
+	})
+}